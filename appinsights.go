@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// appInsightsComponent is the subset of `az monitor app-insights component create` and
+// `show` output used by the deployment
+type appInsightsComponent struct {
+	ID               string `json:"id"`
+	ConnectionString string `json:"connectionString"`
+}
+
+// appInsightsExists reports whether the configured Application Insights component
+// already exists. The check is read-only, so it also runs in dry-run mode
+func appInsightsExists(ctx context.Context, cfg Config) (bool, error) {
+	resp, err := resourcesClient.CheckExistenceByID(ctx, appInsightsID(cfg), armInsightsAPIVersion, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether Application Insights component %s exists: %w", cfg.AppInsightsName, err)
+	}
+	return resp.Success, nil
+}
+
+// showAppInsights fetches the configured Application Insights component using
+// `az monitor app-insights component show`
+func showAppInsights(ctx context.Context, cfg Config) (*appInsightsComponent, error) {
+	// The output holds the connection string, so it is never streamed to the log
+	quiet := cfg
+	quiet.Verbose = false
+	output, err := runCommand(ctx, quiet, "", "az", "monitor", "app-insights", "component", "show",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--app", cfg.AppInsightsName,
+		"--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("az monitor app-insights component show failed: %v\nOutput: %s", err, outputTail(output))
+	}
+
+	var component appInsightsComponent
+	if err := json.Unmarshal(output, &component); err != nil {
+		return nil, fmt.Errorf("failed to parse Application Insights component: %v", err)
+	}
+	return &component, nil
+}
+
+// linkAppInsights points an existing Function App at the Application Insights
+// component by setting APPLICATIONINSIGHTS_CONNECTION_STRING, which
+// `az functionapp create --app-insights` only does for new apps
+func linkAppInsights(ctx context.Context, cfg Config) error {
+	if cfg.DryRun {
+		planDryRun("set APPLICATIONINSIGHTS_CONNECTION_STRING on Function App %s from Application Insights component %s",
+			cfg.AzureFunctionAppName, cfg.AppInsightsName)
+		return nil
+	}
+	component, err := showAppInsights(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if component.ConnectionString == "" {
+		return fmt.Errorf("Application Insights component %s has no connection string", cfg.AppInsightsName)
+	}
+	return configureAppSettings(ctx, cfg, map[string]string{"APPLICATIONINSIGHTS_CONNECTION_STRING": component.ConnectionString})
+}
+
+// createAppInsights creates the Application Insights component the Function App reports
+// to using `az monitor app-insights component create`
+func createAppInsights(ctx context.Context, cfg Config) (*appInsightsComponent, error) {
+	cmdArgs := []string{
+		"monitor", "app-insights", "component", "create",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--app", cfg.AppInsightsName,
+		"--location", cfg.AzureLocation,
+		"--kind", "web",
+		"--application-type", "web",
+		"--output", "json",
+	}
+
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		cmdArgs = append(cmdArgs, "--tags")
+		cmdArgs = append(cmdArgs, tagArgs(tags)...)
+	}
+
+	if cfg.DryRun {
+		planDryRun("create Application Insights component %s (az %s)", cfg.AppInsightsName, strings.Join(cmdArgs, " "))
+		return &appInsightsComponent{ID: appInsightsID(cfg)}, nil
+	}
+
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("az monitor app-insights component create failed: %v\nOutput: %s", err, outputTail(output))
+	}
+
+	var component appInsightsComponent
+	if err := json.Unmarshal(output, &component); err != nil {
+		return nil, fmt.Errorf("failed to parse Application Insights component: %v", err)
+	}
+	return &component, nil
+}
+
+// deleteAppInsights deletes the configured Application Insights component using
+// `az monitor app-insights component delete`
+func deleteAppInsights(ctx context.Context, cfg Config) error {
+	output, err := runCommand(ctx, cfg, "", "az", "monitor", "app-insights", "component", "delete",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--app", cfg.AppInsightsName,
+	)
+	if err != nil {
+		return fmt.Errorf("az monitor app-insights component delete failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	return nil
+}
+
+// appInsightsID builds the Azure resource ID of the configured Application Insights component
+func appInsightsID(cfg Config) string {
+	return fmt.Sprintf("%s/providers/Microsoft.Insights/components/%s", resourceGroupID(cfg), cfg.AppInsightsName)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Template schemas and API versions used in the exported ARM template
+const (
+	armSubscriptionSchema  = "https://schema.management.azure.com/schemas/2018-05-01/subscriptionDeploymentTemplate.json#"
+	armResourceGroupSchema = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+	armResourcesAPIVersion = "2022-09-01"
+	armStorageAPIVersion   = "2023-01-01"
+	armWebAPIVersion       = "2022-09-01"
+	armInsightsAPIVersion  = "2020-02-02"
+)
+
+// armNestedDeploymentName names the deployment holding the resource group's resources
+const armNestedDeploymentName = "function-app-resources"
+
+// armParameterPattern matches the characters not allowed in ARM parameter names
+var armParameterPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// armTemplate is an ARM deployment template
+type armTemplate struct {
+	Schema         string                  `json:"$schema"`
+	ContentVersion string                  `json:"contentVersion"`
+	Parameters     map[string]armParameter `json:"parameters,omitempty"`
+	Resources      []map[string]any        `json:"resources"`
+}
+
+// armParameter declares a template parameter
+type armParameter struct {
+	Type     string            `json:"type"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ExportARM writes an ARM template equivalent to the deployment to the --export-arm path,
+// or to stdout when it is empty or "-", instead of deploying. Only the configuration is read,
+// so no Azure credentials are needed
+func ExportARM(ctx context.Context, config Config) (err error) {
+	steps := stepTracker{parent: ctx, timeout: config.StepTimeout}
+	defer func() { steps.finish(err) }()
+
+	steps.begin(StepValidateConfig, "")
+	if err := validateConfig(&config); err != nil {
+		return &StepError{Step: StepValidateConfig, Err: err}
+	}
+
+	path := config.ExportARM
+	if path == "" {
+		path = "-"
+	}
+	steps.begin(StepExportARM, path)
+	template, err := buildARMTemplate(config)
+	if err != nil {
+		return &StepError{Step: StepExportARM, Err: err}
+	}
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return &StepError{Step: StepExportARM, Err: err}
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		return &StepError{Step: StepExportARM, Err: fmt.Errorf("failed to write ARM template to %s: %v", path, err)}
+	}
+	if path != "-" {
+		log.Println("ARM template written to", path)
+	}
+	log.Printf("Deploy it with: az deployment sub create --location %s --template-file <template>", config.AzureLocation)
+	return nil
+}
+
+// buildARMTemplate builds a subscription-scope template that creates the resource group,
+// unless it is reused, and deploys the other resources into it through a nested
+// deployment. Secret app settings become secure parameters instead of literal values
+func buildARMTemplate(cfg Config) (armTemplate, error) {
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return armTemplate{}, err
+	}
+
+	inner := armTemplate{
+		Schema:         armResourceGroupSchema,
+		ContentVersion: "1.0.0.0",
+		Parameters:     map[string]armParameter{},
+	}
+	storageRef := fmt.Sprintf("resourceId('Microsoft.Storage/storageAccounts', '%s')", cfg.AzureStorageAccountName)
+	sharedStorage := !strings.EqualFold(storageResourceGroup(cfg), cfg.AzureResourceGroupName)
+	if sharedStorage {
+		storageRef = fmt.Sprintf("resourceId('%s', 'Microsoft.Storage/storageAccounts', '%s')",
+			storageResourceGroup(cfg), cfg.AzureStorageAccountName)
+	}
+	planRef := fmt.Sprintf("resourceId('Microsoft.Web/serverfarms', '%s')", cfg.PlanName)
+	siteDependsOn := []string{"[" + planRef + "]"}
+
+	// Storage account, with its blob service settings and containers. An existing
+	// account is only referenced
+	containerDependsOn := []string{}
+	if !cfg.UseExistingStorageAccount {
+		params, err := storageAccountParameters(cfg)
+		if err != nil {
+			return armTemplate{}, err
+		}
+		account, err := armResource("Microsoft.Storage/storageAccounts", armStorageAPIVersion, cfg.AzureStorageAccountName, params)
+		if err != nil {
+			return armTemplate{}, err
+		}
+		inner.Resources = append(inner.Resources, account)
+		siteDependsOn = append(siteDependsOn, "["+storageRef+"]")
+		containerDependsOn = append(containerDependsOn, "["+storageRef+"]")
+
+		if cfg.BlobSoftDeleteDays > 0 || cfg.BlobVersioning {
+			blobService, err := armResource("Microsoft.Storage/storageAccounts/blobServices", armStorageAPIVersion,
+				cfg.AzureStorageAccountName+"/default", blobServiceParameters(cfg))
+			if err != nil {
+				return armTemplate{}, err
+			}
+			blobService["dependsOn"] = []string{"[" + storageRef + "]"}
+			inner.Resources = append(inner.Resources, blobService)
+		}
+	}
+	containers, err := parseBlobContainers(cfg.BlobContainers)
+	if err != nil {
+		return armTemplate{}, err
+	}
+	access, err := parsePublicAccess(cfg.BlobContainerPublicAccess)
+	if err != nil {
+		return armTemplate{}, err
+	}
+	// The nested deployment cannot create containers in another resource group
+	if sharedStorage && len(containers) > 0 {
+		log.Printf("Warning: BLOB_CONTAINERS are not exported, storage account %s is in resource group %s",
+			cfg.AzureStorageAccountName, storageResourceGroup(cfg))
+		containers = nil
+	}
+	for _, name := range containers {
+		inner.Resources = append(inner.Resources, map[string]any{
+			"type":       "Microsoft.Storage/storageAccounts/blobServices/containers",
+			"apiVersion": armStorageAPIVersion,
+			"name":       cfg.AzureStorageAccountName + "/default/" + name,
+			"dependsOn":  containerDependsOn,
+			"properties": map[string]any{"publicAccess": access},
+		})
+	}
+
+	// Hosting plan. The consumption plan az creates implicitly is declared explicitly
+	linux := functionAppOS(cfg) == osTypeLinux
+	plan := map[string]any{
+		"type":       "Microsoft.Web/serverfarms",
+		"apiVersion": armWebAPIVersion,
+		"name":       cfg.PlanName,
+		"location":   cfg.AzureLocation,
+		"tags":       tags,
+		"properties": map[string]any{"reserved": linux},
+	}
+	switch cfg.PlanType {
+	case planTypeConsumption:
+		plan["sku"] = map[string]any{"name": "Y1", "tier": "Dynamic"}
+	case planTypePremium:
+		plan["kind"] = "elastic"
+		plan["sku"] = map[string]any{"name": cfg.PlanSKU, "tier": "ElasticPremium"}
+	default:
+		plan["sku"] = map[string]any{"name": cfg.PlanSKU}
+	}
+	inner.Resources = append(inner.Resources, plan)
+
+	if cfg.EnableAppInsights {
+		inner.Resources = append(inner.Resources, map[string]any{
+			"type":       "Microsoft.Insights/components",
+			"apiVersion": armInsightsAPIVersion,
+			"name":       cfg.AppInsightsName,
+			"location":   cfg.AzureLocation,
+			"tags":       tags,
+			"kind":       "web",
+			"properties": map[string]any{"Application_Type": "web"},
+		})
+		siteDependsOn = append(siteDependsOn,
+			fmt.Sprintf("[resourceId('Microsoft.Insights/components', '%s')]", cfg.AppInsightsName))
+	}
+
+	appSettings, err := armAppSettings(cfg, storageRef, inner.Parameters)
+	if err != nil {
+		return armTemplate{}, err
+	}
+	siteConfig := map[string]any{"appSettings": appSettings}
+	origins, err := parseCORSOrigins(cfg.AllowedOrigins)
+	if err != nil {
+		return armTemplate{}, err
+	}
+	if len(origins) > 0 {
+		siteConfig["cors"] = map[string]any{"allowedOrigins": origins}
+	}
+	kind := "functionapp"
+	if cfg.ContainerImage != "" {
+		kind = "functionapp,linux,container"
+		siteConfig["linuxFxVersion"] = desiredLinuxFxVersion(cfg)
+	} else if linux {
+		kind = "functionapp,linux"
+		siteConfig["linuxFxVersion"] = desiredLinuxFxVersion(cfg)
+	} else if cfg.FunctionRuntime == "powershell" {
+		siteConfig["powerShellVersion"] = cfg.FunctionRuntimeVersion
+	}
+	site := map[string]any{
+		"type":       "Microsoft.Web/sites",
+		"apiVersion": armWebAPIVersion,
+		"name":       cfg.AzureFunctionAppName,
+		"location":   cfg.AzureLocation,
+		"tags":       tags,
+		"kind":       kind,
+		"dependsOn":  siteDependsOn,
+		"properties": map[string]any{
+			"serverFarmId": "[" + planRef + "]",
+			"reserved":     linux,
+			"siteConfig":   siteConfig,
+		},
+	}
+	if identity := armSiteIdentity(cfg); identity != nil {
+		site["identity"] = identity
+	}
+	inner.Resources = append(inner.Resources, site)
+
+	// The subscription-scope template creates the group and passes the secure
+	// parameters through to the nested deployment
+	outer := armTemplate{
+		Schema:         armSubscriptionSchema,
+		ContentVersion: "1.0.0.0",
+		Parameters:     inner.Parameters,
+	}
+	deploymentDependsOn := []string{}
+	if cfg.ReuseResourceGroup != reuseGroupRequired {
+		outer.Resources = append(outer.Resources, map[string]any{
+			"type":       "Microsoft.Resources/resourceGroups",
+			"apiVersion": armResourcesAPIVersion,
+			"name":       cfg.AzureResourceGroupName,
+			"location":   cfg.AzureLocation,
+			"tags":       tags,
+		})
+		deploymentDependsOn = append(deploymentDependsOn,
+			fmt.Sprintf("[resourceId('Microsoft.Resources/resourceGroups', '%s')]", cfg.AzureResourceGroupName))
+	}
+	passed := map[string]any{}
+	for name := range inner.Parameters {
+		passed[name] = map[string]string{"value": fmt.Sprintf("[parameters('%s')]", name)}
+	}
+	outer.Resources = append(outer.Resources, map[string]any{
+		"type":          "Microsoft.Resources/deployments",
+		"apiVersion":    armResourcesAPIVersion,
+		"name":          armNestedDeploymentName,
+		"resourceGroup": cfg.AzureResourceGroupName,
+		"dependsOn":     deploymentDependsOn,
+		"properties": map[string]any{
+			"mode":                        "Incremental",
+			"expressionEvaluationOptions": map[string]string{"scope": "inner"},
+			"parameters":                  passed,
+			"template":                    inner,
+		},
+	})
+	return outer, nil
+}
+
+// armResource renders SDK create parameters, which marshal to the ARM resource body, as
+// a template resource of the given type
+func armResource(resourceType, apiVersion, name string, params any) (map[string]any, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	resource := map[string]any{}
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, err
+	}
+	resource["type"] = resourceType
+	resource["apiVersion"] = apiVersion
+	resource["name"] = name
+	return resource, nil
+}
+
+// armAppSettings returns the Function App settings az would configure, followed by
+// APP_SETTINGS. Secret settings are added to parameters as secure strings and
+// referenced instead of written to the template
+func armAppSettings(cfg Config, storageRef string, parameters map[string]armParameter) ([]map[string]string, error) {
+	connectionString := fmt.Sprintf(
+		"[format('DefaultEndpointsProtocol=https;AccountName={0};AccountKey={1};EndpointSuffix={2}', '%s', listKeys(%s, '%s').keys[0].value, environment().suffixes.storage)]",
+		cfg.AzureStorageAccountName, storageRef, armStorageAPIVersion)
+
+	settings := []map[string]string{
+		{"name": "AzureWebJobsStorage", "value": connectionString},
+		{"name": "FUNCTIONS_EXTENSION_VERSION", "value": "~" + cfg.FunctionsVersion},
+		{"name": "FUNCTIONS_WORKER_RUNTIME", "value": cfg.FunctionRuntime},
+	}
+	// Consumption and premium plans keep the app content on an Azure Files share
+	if cfg.PlanType != planTypeDedicated {
+		settings = append(settings,
+			map[string]string{"name": "WEBSITE_CONTENTAZUREFILECONNECTIONSTRING", "value": connectionString},
+			map[string]string{"name": "WEBSITE_CONTENTSHARE", "value": strings.ToLower(cfg.AzureFunctionAppName)})
+	}
+	if cfg.FunctionRuntime == "node" {
+		settings = append(settings, map[string]string{"name": "WEBSITE_NODE_DEFAULT_VERSION", "value": "~" + cfg.FunctionRuntimeVersion})
+	}
+	// The host pulls CONTAINER_IMAGE with these credentials
+	if cfg.RegistryServer != "" {
+		settings = append(settings, map[string]string{"name": "DOCKER_REGISTRY_SERVER_URL", "value": "https://" + cfg.RegistryServer})
+	}
+	if cfg.RegistryUsername != "" {
+		parameters["registryPassword"] = armParameter{
+			Type:     "securestring",
+			Metadata: map[string]string{"description": "Password of the CONTAINER_IMAGE registry"},
+		}
+		settings = append(settings,
+			map[string]string{"name": "DOCKER_REGISTRY_SERVER_USERNAME", "value": cfg.RegistryUsername},
+			map[string]string{"name": "DOCKER_REGISTRY_SERVER_PASSWORD", "value": "[parameters('registryPassword')]"})
+	}
+	if cfg.EnableAppInsights {
+		settings = append(settings, map[string]string{
+			"name": "APPLICATIONINSIGHTS_CONNECTION_STRING",
+			"value": fmt.Sprintf("[reference(resourceId('Microsoft.Insights/components', '%s'), '%s').ConnectionString]",
+				cfg.AppInsightsName, armInsightsAPIVersion),
+		})
+	}
+
+	extra, err := parseAppSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		value := extra[key]
+		if maskSettingValue(key, value) != value {
+			name := armParameterPattern.ReplaceAllString(key, "_")
+			parameters[name] = armParameter{
+				Type:     "securestring",
+				Metadata: map[string]string{"description": "Value of the " + key + " app setting"},
+			}
+			value = fmt.Sprintf("[parameters('%s')]", name)
+		} else if strings.HasPrefix(value, "[") {
+			// A leading bracket would otherwise be read as a template expression
+			value = "[" + value
+		}
+		settings = append(settings, map[string]string{"name": key, "value": value})
+	}
+	return settings, nil
+}
+
+// armSiteIdentity returns the Function App identity for ASSIGN_IDENTITY and
+// USER_ASSIGNED_IDENTITY_ID, or nil when none is configured
+func armSiteIdentity(cfg Config) map[string]any {
+	types := []string{}
+	if cfg.AssignIdentity {
+		types = append(types, "SystemAssigned")
+	}
+	identity := map[string]any{}
+	if cfg.UserAssignedIdentityID != "" {
+		types = append(types, "UserAssigned")
+		identity["userAssignedIdentities"] = map[string]any{cfg.UserAssignedIdentityID: map[string]any{}}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	identity["type"] = strings.Join(types, ", ")
+	return identity
+}
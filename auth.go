@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// Authentication methods accepted by AUTH_METHOD
+const (
+	authMethodDefault      = "default"
+	authMethodClientSecret = "client_secret"
+)
+
+// validateAuth checks AUTH_METHOD and, for service principal authentication, that the
+// client ID, client secret and tenant ID are all set
+func validateAuth(cfg Config) error {
+	switch cfg.AuthMethod {
+	case authMethodDefault:
+		return nil
+	case authMethodClientSecret:
+		missingVars := []string{}
+		if cfg.ClientID == "" {
+			missingVars = append(missingVars, "AZURE_CLIENT_ID")
+		}
+		if cfg.ClientSecret == "" {
+			missingVars = append(missingVars, "AZURE_CLIENT_SECRET")
+		}
+		if cfg.TenantID == "" {
+			missingVars = append(missingVars, "AZURE_TENANT_ID")
+		}
+		if len(missingVars) > 0 {
+			return fmt.Errorf("AUTH_METHOD %s requires %v", authMethodClientSecret, missingVars)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid AUTH_METHOD %q, accepted values are: %s, %s",
+			cfg.AuthMethod, authMethodDefault, authMethodClientSecret)
+	}
+}
+
+// missingSubscriptionCodes are the Azure error codes returned for a subscription that
+// does not exist or that the credential has no access to
+var missingSubscriptionCodes = []string{"SubscriptionNotFound", "InvalidSubscriptionId"}
+
+// verifyCredential makes a cheap authenticated call, listing at most one resource group,
+// so that a missing login, invalid credential or unknown subscription fails before any
+// resource is created.
+// The call is read-only, so it also runs in dry-run mode
+func verifyCredential(ctx context.Context, cfg Config) error {
+	pager := resourceGroupClient.NewListPager(&armresources.ResourceGroupsClientListOptions{Top: to.Ptr[int32](1)})
+	if _, err := pager.NextPage(ctx); err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && slices.Contains(missingSubscriptionCodes, respErr.ErrorCode) {
+			return fmt.Errorf("subscription %s does not exist or is not visible to this credential, check AZURE_SUBSCRIPTION_ID: %w",
+				cfg.AzureSubscriptionID, err)
+		}
+		hint := "run `az login` or check the environment credentials"
+		if cfg.AuthMethod == authMethodClientSecret {
+			hint = "check AZURE_CLIENT_ID, AZURE_CLIENT_SECRET and AZURE_TENANT_ID"
+		}
+		return fmt.Errorf("Azure credential check failed for subscription %s, not logged in or credential invalid (%s): %w",
+			cfg.AzureSubscriptionID, hint, err)
+	}
+	log.Println("Azure credential verified for subscription", cfg.AzureSubscriptionID)
+	return nil
+}
+
+// newCredential returns the Azure SDK credential selected by AUTH_METHOD
+func newCredential(cfg Config) (azcore.TokenCredential, error) {
+	if cfg.AuthMethod == authMethodClientSecret {
+		log.Println("Authenticating with service principal", cfg.ClientID)
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions(cfg)})
+	}
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: clientOptions(cfg),
+	})
+}
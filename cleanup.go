@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Run modes selected by MODE or the first command line argument
+const (
+	modeDeploy        = "deploy"
+	modeCleanup       = "cleanup"
+	modeListLocations = "list-locations"
+	modeExportARM     = "export-arm"
+	modePlan          = "plan"
+)
+
+// Cleanup deletes the configured resource group left behind by a previous run without
+// deploying anything. It confirms the group exists and logs every resource in it first,
+// and asks for confirmation unless AUTO_APPROVE is set. Declining is not an error
+func Cleanup(ctx context.Context, config Config) (err error) {
+	steps := stepTracker{parent: ctx, timeout: config.StepTimeout}
+	defer func() { steps.finish(err) }()
+
+	// Step 1: Only the subscription and resource group are needed to clean up
+	steps.begin(StepValidateConfig, "")
+	if err := validateCleanupConfig(config); err != nil {
+		return &StepError{Step: StepValidateConfig, Err: err}
+	}
+
+	// Step 2: Initialize Azure SDK credentials and clients
+	steps.begin(StepCredentials, "")
+	cred, err := newCredential(config)
+	if err != nil {
+		return &StepError{Step: StepCredentials, Err: err}
+	}
+
+	steps.begin(StepInitClients, config.AzureSubscriptionID)
+	if err := initClients(config, cred); err != nil {
+		return &StepError{Step: StepInitClients, Err: err}
+	}
+
+	stepCtx := steps.begin(StepVerifyCredential, config.AzureSubscriptionID)
+	if err := verifyCredential(stepCtx, config); err != nil {
+		return &StepError{Step: StepVerifyCredential, Err: err}
+	}
+
+	// Step 3: Confirm the resource group exists and list what is about to be deleted.
+	// Both calls are read-only, so they also run in dry-run mode
+	stepCtx = steps.begin(StepCleanup, config.AzureResourceGroupName)
+	if _, err := resourceGroupClient.Get(stepCtx, config.AzureResourceGroupName, nil); err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return &StepError{Step: StepCleanup, Err: fmt.Errorf("resource group %s does not exist, nothing to clean up", config.AzureResourceGroupName)}
+		}
+		return &StepError{Step: StepCleanup, Err: err}
+	}
+	if err := logResourceGroupContents(stepCtx, config); err != nil {
+		return &StepError{Step: StepCleanup, Err: err}
+	}
+
+	// Step 4: Delete the resource group and everything in it
+	confirmed, err := confirmDeletion(config, true)
+	if err != nil {
+		return &StepError{Step: StepCleanup, Err: err}
+	}
+	if !confirmed {
+		log.Println("Cleanup cancelled, nothing was deleted.")
+		return nil
+	}
+	if err := cleanup(stepCtx, config); err != nil {
+		return &StepError{Step: StepCleanup, Err: err}
+	}
+	steps.finish(nil)
+
+	if config.DryRun {
+		logDryRunSummary()
+	} else {
+		log.Println("Resources cleaned up successfully.")
+	}
+	return nil
+}
+
+// validateCleanupConfig checks the settings used by Cleanup. A reused resource group was
+// not created by this tool, so it is never deleted
+func validateCleanupConfig(cfg Config) error {
+	missingVars := []string{}
+	if cfg.AzureSubscriptionID == "" {
+		missingVars = append(missingVars, "AZURE_SUBSCRIPTION_ID")
+	}
+	if cfg.AzureResourceGroupName == "" {
+		missingVars = append(missingVars, "AZURE_RESOURCE_GROUP_NAME")
+	}
+	if len(missingVars) > 0 {
+		return fmt.Errorf("missing required environment variables: %v (set them in the environment, .env or a --config file; %s)",
+			missingVars, configPrecedence)
+	}
+
+	if cfg.ReuseResourceGroup != reuseGroupOff {
+		return fmt.Errorf("REUSE_RESOURCE_GROUP=%s is set, refusing to delete resource group %s, which may not have been created by this tool",
+			cfg.ReuseResourceGroup, cfg.AzureResourceGroupName)
+	}
+	if _, err := lookupAzureCloud(cfg.AzureCloud); err != nil {
+		return fmt.Errorf("invalid AZURE_CLOUD: %w", err)
+	}
+	return validateAuth(cfg)
+}
+
+// logResourceGroupContents logs every resource in the configured resource group
+func logResourceGroupContents(ctx context.Context, cfg Config) error {
+	log.Printf("Resource group %s and the following resources will be deleted:", cfg.AzureResourceGroupName)
+
+	count := 0
+	pager := resourcesClient.NewListByResourceGroupPager(cfg.AzureResourceGroupName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list resources in %s: %v", cfg.AzureResourceGroupName, err)
+		}
+		for _, resource := range page.Value {
+			log.Printf("  %s (%s)", derefString(resource.Name), derefString(resource.Type))
+			count++
+		}
+	}
+	if count == 0 {
+		log.Println("  (no resources)")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// ResourceGroupAPI is the subset of armresources.ResourceGroupsClient used by the
+// deployment, so that it can be replaced by a fake
+type ResourceGroupAPI interface {
+	CheckExistence(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientCheckExistenceOptions) (armresources.ResourceGroupsClientCheckExistenceResponse, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error)
+	Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error)
+	BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error)
+	NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse]
+}
+
+// StorageAccountAPI is the subset of armstorage.AccountsClient used by the deployment,
+// so that it can be replaced by a fake
+type StorageAccountAPI interface {
+	CheckNameAvailability(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error)
+	BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error)
+	GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error)
+	Delete(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error)
+	ListKeys(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error)
+}
+
+// ResourceAPI is the subset of armresources.Client used by the deployment to look up
+// resources, so that it can be replaced by a fake
+type ResourceAPI interface {
+	CheckExistenceByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientCheckExistenceByIDOptions) (armresources.ClientCheckExistenceByIDResponse, error)
+	GetByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientGetByIDOptions) (armresources.ClientGetByIDResponse, error)
+	NewListByResourceGroupPager(resourceGroupName string, options *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse]
+}
+
+// BlobServiceAPI is the subset of armstorage.BlobServicesClient used by the deployment,
+// so that it can be replaced by a fake
+type BlobServiceAPI interface {
+	SetServiceProperties(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.BlobServiceProperties, options *armstorage.BlobServicesClientSetServicePropertiesOptions) (armstorage.BlobServicesClientSetServicePropertiesResponse, error)
+}
+
+// BlobContainerAPI is the subset of armstorage.BlobContainersClient used by the
+// deployment, so that it can be replaced by a fake
+type BlobContainerAPI interface {
+	Get(ctx context.Context, resourceGroupName string, accountName string, containerName string, options *armstorage.BlobContainersClientGetOptions) (armstorage.BlobContainersClientGetResponse, error)
+	Create(ctx context.Context, resourceGroupName string, accountName string, containerName string, blobContainer armstorage.BlobContainer, options *armstorage.BlobContainersClientCreateOptions) (armstorage.BlobContainersClientCreateResponse, error)
+	Delete(ctx context.Context, resourceGroupName string, accountName string, containerName string, options *armstorage.BlobContainersClientDeleteOptions) (armstorage.BlobContainersClientDeleteResponse, error)
+}
+
+// UsagesAPI is the subset of armstorage.UsagesClient used by the storage quota
+// preflight, so that it can be replaced by a fake
+type UsagesAPI interface {
+	NewListByLocationPager(location string, options *armstorage.UsagesClientListByLocationOptions) *runtime.Pager[armstorage.UsagesClientListByLocationResponse]
+}
+
+// SubscriptionAPI is the subset of armsubscriptions.Client used to list the locations
+// of the subscription, so that it can be replaced by a fake
+type SubscriptionAPI interface {
+	NewListLocationsPager(subscriptionID string, options *armsubscriptions.ClientListLocationsOptions) *runtime.Pager[armsubscriptions.ClientListLocationsResponse]
+}
+
+// CommandRunner runs the az and func CLIs in dir and returns their combined output. It
+// can be replaced to stub the CLIs or to run them remotely or in a container
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args []string, dir string) ([]byte, error)
+}
+
+// The SDK clients must keep satisfying the interfaces
+var (
+	_ ResourceGroupAPI  = (*armresources.ResourceGroupsClient)(nil)
+	_ StorageAccountAPI = (*armstorage.AccountsClient)(nil)
+	_ ResourceAPI       = (*armresources.Client)(nil)
+	_ BlobServiceAPI    = (*armstorage.BlobServicesClient)(nil)
+	_ BlobContainerAPI  = (*armstorage.BlobContainersClient)(nil)
+	_ UsagesAPI         = (*armstorage.UsagesClient)(nil)
+	_ SubscriptionAPI   = (*armsubscriptions.Client)(nil)
+)
+
+// clientsMu guards the global Azure SDK clients, which concurrent deployments share.
+// clientsKey identifies the cloud and subscription they were created for
+var (
+	clientsMu  sync.Mutex
+	clientsKey string
+)
+
+// initClients creates the global Azure SDK clients for the configured subscription.
+// Clients already created for the same cloud and subscription are reused, so that concurrent
+// deployments never replace them while they are in use
+func initClients(cfg Config, cred azcore.TokenCredential) error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	key := cfg.AzureCloud + "/" + cfg.AzureSubscriptionID
+	if clientsKey == key && storageClientFactory != nil {
+		return nil
+	}
+
+	resources, err := armresources.NewClientFactory(cfg.AzureSubscriptionID, cred, armClientOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("resources client factory: %w", err)
+	}
+	storage, err := armstorage.NewClientFactory(cfg.AzureSubscriptionID, cred, armClientOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("storage client factory: %w", err)
+	}
+	subscriptions, err := armsubscriptions.NewClient(cred, armClientOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("subscriptions client: %w", err)
+	}
+
+	resourcesClientFactory = resources
+	resourcesClient = resources.NewClient()
+	resourceGroupClient = resources.NewResourceGroupsClient()
+	storageClientFactory = storage
+	accountsClient = storage.NewAccountsClient()
+	blobServicesClient = storage.NewBlobServicesClient()
+	blobContainersClient = storage.NewBlobContainersClient()
+	usagesClient = storage.NewUsagesClient()
+	subscriptionsClient = subscriptions
+	clientsKey = key
+	return nil
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// errNotFaked is returned by fake methods a test did not set up
+var errNotFaked = errors.New("not faked")
+
+// fakeResourceGroups is a ResourceGroupAPI holding resource groups in memory
+type fakeResourceGroups struct {
+	groups    map[string]armresources.ResourceGroup
+	createErr error
+	created   []string
+}
+
+func (f *fakeResourceGroups) CheckExistence(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientCheckExistenceOptions) (armresources.ResourceGroupsClientCheckExistenceResponse, error) {
+	_, ok := f.groups[resourceGroupName]
+	return armresources.ResourceGroupsClientCheckExistenceResponse{Success: ok}, nil
+}
+
+func (f *fakeResourceGroups) CreateOrUpdate(ctx context.Context, resourceGroupName string, parameters armresources.ResourceGroup, options *armresources.ResourceGroupsClientCreateOrUpdateOptions) (armresources.ResourceGroupsClientCreateOrUpdateResponse, error) {
+	if f.createErr != nil {
+		return armresources.ResourceGroupsClientCreateOrUpdateResponse{}, f.createErr
+	}
+	parameters.ID = to.Ptr("/subscriptions/sub/resourceGroups/" + resourceGroupName)
+	parameters.Name = to.Ptr(resourceGroupName)
+	if f.groups == nil {
+		f.groups = map[string]armresources.ResourceGroup{}
+	}
+	f.groups[resourceGroupName] = parameters
+	f.created = append(f.created, resourceGroupName)
+	return armresources.ResourceGroupsClientCreateOrUpdateResponse{ResourceGroup: parameters}, nil
+}
+
+func (f *fakeResourceGroups) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	group, ok := f.groups[resourceGroupName]
+	if !ok {
+		return armresources.ResourceGroupsClientGetResponse{}, responseError(http.StatusNotFound)
+	}
+	return armresources.ResourceGroupsClientGetResponse{ResourceGroup: group}, nil
+}
+
+func (f *fakeResourceGroups) BeginDelete(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientBeginDeleteOptions) (*runtime.Poller[armresources.ResourceGroupsClientDeleteResponse], error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeResourceGroups) NewListPager(options *armresources.ResourceGroupsClientListOptions) *runtime.Pager[armresources.ResourceGroupsClientListResponse] {
+	return runtime.NewPager(runtime.PagingHandler[armresources.ResourceGroupsClientListResponse]{
+		More: func(armresources.ResourceGroupsClientListResponse) bool { return false },
+		Fetcher: func(context.Context, *armresources.ResourceGroupsClientListResponse) (armresources.ResourceGroupsClientListResponse, error) {
+			return armresources.ResourceGroupsClientListResponse{}, nil
+		},
+	})
+}
+
+// fakeStorageAccounts is a StorageAccountAPI holding storage accounts in memory.
+// checkErrs are returned by the first CheckNameAvailability calls, one per call
+type fakeStorageAccounts struct {
+	mu          sync.Mutex
+	accounts    map[string]armstorage.Account
+	taken       bool
+	checkErrs   []error
+	checkCalls  int
+	createCalls int
+}
+
+func (f *fakeStorageAccounts) CheckNameAvailability(ctx context.Context, accountName armstorage.AccountCheckNameAvailabilityParameters, options *armstorage.AccountsClientCheckNameAvailabilityOptions) (armstorage.AccountsClientCheckNameAvailabilityResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkCalls++
+	if len(f.checkErrs) > 0 {
+		err := f.checkErrs[0]
+		f.checkErrs = f.checkErrs[1:]
+		return armstorage.AccountsClientCheckNameAvailabilityResponse{}, err
+	}
+	result := armstorage.CheckNameAvailabilityResult{NameAvailable: to.Ptr(!f.taken)}
+	if f.taken {
+		result.Message = to.Ptr("The storage account named " + *accountName.Name + " is already taken.")
+	}
+	return armstorage.AccountsClientCheckNameAvailabilityResponse{CheckNameAvailabilityResult: result}, nil
+}
+
+func (f *fakeStorageAccounts) BeginCreate(ctx context.Context, resourceGroupName string, accountName string, parameters armstorage.AccountCreateParameters, options *armstorage.AccountsClientBeginCreateOptions) (*runtime.Poller[armstorage.AccountsClientCreateResponse], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createCalls++
+	account := armstorage.Account{
+		ID:       to.Ptr("/subscriptions/sub/resourceGroups/" + resourceGroupName + "/providers/Microsoft.Storage/storageAccounts/" + accountName),
+		Name:     to.Ptr(accountName),
+		Location: parameters.Location,
+		Kind:     parameters.Kind,
+		SKU:      parameters.SKU,
+	}
+	if f.accounts == nil {
+		f.accounts = map[string]armstorage.Account{}
+	}
+	f.accounts[accountName] = account
+	return donePoller[armstorage.AccountsClientCreateResponse](account)
+}
+
+func (f *fakeStorageAccounts) GetProperties(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientGetPropertiesOptions) (armstorage.AccountsClientGetPropertiesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	account, ok := f.accounts[accountName]
+	if !ok {
+		return armstorage.AccountsClientGetPropertiesResponse{}, responseError(http.StatusNotFound)
+	}
+	return armstorage.AccountsClientGetPropertiesResponse{Account: account}, nil
+}
+
+func (f *fakeStorageAccounts) Delete(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientDeleteOptions) (armstorage.AccountsClientDeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.accounts, accountName)
+	return armstorage.AccountsClientDeleteResponse{}, nil
+}
+
+func (f *fakeStorageAccounts) ListKeys(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (armstorage.AccountsClientListKeysResponse, error) {
+	return armstorage.AccountsClientListKeysResponse{
+		AccountListKeysResult: armstorage.AccountListKeysResult{
+			Keys: []*armstorage.AccountKey{{KeyName: to.Ptr("key1"), Value: to.Ptr("secret")}},
+		},
+	}, nil
+}
+
+// fakeResources is a ResourceAPI reporting the resource IDs in existing as present
+type fakeResources struct {
+	existing []string
+}
+
+func (f *fakeResources) CheckExistenceByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientCheckExistenceByIDOptions) (armresources.ClientCheckExistenceByIDResponse, error) {
+	return armresources.ClientCheckExistenceByIDResponse{Success: slices.Contains(f.existing, resourceID)}, nil
+}
+
+func (f *fakeResources) GetByID(ctx context.Context, resourceID string, apiVersion string, options *armresources.ClientGetByIDOptions) (armresources.ClientGetByIDResponse, error) {
+	return armresources.ClientGetByIDResponse{}, errNotFaked
+}
+
+func (f *fakeResources) NewListByResourceGroupPager(resourceGroupName string, options *armresources.ClientListByResourceGroupOptions) *runtime.Pager[armresources.ClientListByResourceGroupResponse] {
+	return runtime.NewPager(runtime.PagingHandler[armresources.ClientListByResourceGroupResponse]{
+		More: func(armresources.ClientListByResourceGroupResponse) bool { return false },
+		Fetcher: func(context.Context, *armresources.ClientListByResourceGroupResponse) (armresources.ClientListByResourceGroupResponse, error) {
+			return armresources.ClientListByResourceGroupResponse{}, nil
+		},
+	})
+}
+
+// fakeUsages is a UsagesAPI reporting the Storage Account usage as current of limit, or
+// no usage when limit is zero
+type fakeUsages struct {
+	current, limit int32
+}
+
+func (f *fakeUsages) NewListByLocationPager(location string, options *armstorage.UsagesClientListByLocationOptions) *runtime.Pager[armstorage.UsagesClientListByLocationResponse] {
+	resp := armstorage.UsagesClientListByLocationResponse{}
+	if f.limit > 0 {
+		resp.Value = []*armstorage.Usage{{
+			Name:         &armstorage.UsageName{Value: to.Ptr(storageAccountsUsage)},
+			CurrentValue: to.Ptr(f.current),
+			Limit:        to.Ptr(f.limit),
+		}}
+	}
+	return runtime.NewPager(runtime.PagingHandler[armstorage.UsagesClientListByLocationResponse]{
+		More: func(armstorage.UsagesClientListByLocationResponse) bool { return false },
+		Fetcher: func(context.Context, *armstorage.UsagesClientListByLocationResponse) (armstorage.UsagesClientListByLocationResponse, error) {
+			return resp, nil
+		},
+	})
+}
+
+// fakeSubscriptions is a SubscriptionAPI listing the given physical locations, or
+// failing with err
+type fakeSubscriptions struct {
+	locations []string
+	err       error
+}
+
+func (f *fakeSubscriptions) NewListLocationsPager(subscriptionID string, options *armsubscriptions.ClientListLocationsOptions) *runtime.Pager[armsubscriptions.ClientListLocationsResponse] {
+	resp := armsubscriptions.ClientListLocationsResponse{}
+	for _, name := range f.locations {
+		resp.Value = append(resp.Value, &armsubscriptions.Location{
+			Name:        to.Ptr(name),
+			DisplayName: to.Ptr(strings.ToUpper(name)),
+			Metadata:    &armsubscriptions.LocationMetadata{RegionType: to.Ptr(armsubscriptions.RegionTypePhysical)},
+		})
+	}
+	return runtime.NewPager(runtime.PagingHandler[armsubscriptions.ClientListLocationsResponse]{
+		More: func(armsubscriptions.ClientListLocationsResponse) bool { return false },
+		Fetcher: func(context.Context, *armsubscriptions.ClientListLocationsResponse) (armsubscriptions.ClientListLocationsResponse, error) {
+			return resp, f.err
+		},
+	})
+}
+
+// commandCall records one CommandRunner invocation
+type commandCall struct {
+	name string
+	args []string
+	dir  string
+}
+
+// fakeCommandRunner is a CommandRunner recording every call and answering it with
+// respond, or with empty output when respond is nil
+type fakeCommandRunner struct {
+	mu      sync.Mutex
+	calls   []commandCall
+	respond func(call commandCall) ([]byte, error)
+}
+
+func (f *fakeCommandRunner) Run(ctx context.Context, name string, args []string, dir string) ([]byte, error) {
+	call := commandCall{name: name, args: args, dir: dir}
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	if f.respond == nil {
+		return nil, nil
+	}
+	return f.respond(call)
+}
+
+// donePoller returns a poller for an operation that completed synchronously with body
+func donePoller[T any](body any) (*runtime.Poller[T], error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPut, "https://management.azure.com/fake", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+	pl := runtime.NewPipeline("fake", "v0", runtime.PipelineOptions{}, &policy.ClientOptions{})
+	return runtime.NewPoller[T](resp, pl, nil)
+}
+
+// responseError builds the error the SDK clients return for an HTTP status code
+func responseError(status int) error {
+	return &azcore.ResponseError{StatusCode: status, RawResponse: &http.Response{StatusCode: status, Header: http.Header{}}}
+}
+
+// useFakeClients replaces the global Azure SDK clients for the duration of the test. The
+// preflight clients report no usage and only the test location; a test may replace them
+// after this call
+func useFakeClients(t *testing.T, groups ResourceGroupAPI, accounts StorageAccountAPI, resources ResourceAPI) {
+	t.Helper()
+	prevGroups, prevAccounts, prevResources := resourceGroupClient, accountsClient, resourcesClient
+	prevUsages, prevSubscriptions := usagesClient, subscriptionsClient
+	resourceGroupClient, accountsClient, resourcesClient = groups, accounts, resources
+	usagesClient = &fakeUsages{}
+	subscriptionsClient = &fakeSubscriptions{locations: []string{testConfig().AzureLocation}}
+	t.Cleanup(func() {
+		resourceGroupClient, accountsClient, resourcesClient = prevGroups, prevAccounts, prevResources
+		usagesClient, subscriptionsClient = prevUsages, prevSubscriptions
+	})
+}
+
+// testConfig returns a minimal valid configuration whose retries do not wait
+func testConfig() Config {
+	return Config{
+		AzureSubscriptionID:     "sub",
+		AzureLocation:           "westeurope",
+		AzureResourceGroupName:  "rg-test",
+		AzureStorageAccountName: "sttest",
+		AzureFunctionAppName:    "func-test",
+		StorageSKU:              string(armstorage.SKUNameStandardLRS),
+		StorageAccessTier:       string(armstorage.AccessTierHot),
+		StorageKind:             string(armstorage.KindStorageV2),
+		StorageMinTLS:           string(armstorage.MinimumTLSVersionTLS12),
+		StorageHTTPSOnly:        true,
+		EncryptionKeySource:     string(armstorage.KeySourceMicrosoftStorage),
+		NetworkDefaultAction:    string(armstorage.DefaultActionAllow),
+		FunctionRuntime:         "node",
+		FunctionRuntimeVersion:  "18",
+		FunctionsVersion:        "4",
+		PlanType:                planTypeConsumption,
+		MaxRetries:              2,
+		RetryBaseDelay:          time.Millisecond,
+		StepTimeout:             time.Minute,
+		CLITimeout:              time.Minute,
+	}
+}
+
+func TestCreateResourceGroup(t *testing.T) {
+	groups := &fakeResourceGroups{}
+	useFakeClients(t, groups, &fakeStorageAccounts{}, &fakeResources{})
+	cfg := testConfig()
+	cfg.ResourceTags = "env=test"
+
+	group, err := createResourceGroup(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("createResourceGroup: %v", err)
+	}
+	if *group.Name != cfg.AzureResourceGroupName || *group.Location != cfg.AzureLocation {
+		t.Errorf("created %s in %s, want %s in %s", *group.Name, *group.Location, cfg.AzureResourceGroupName, cfg.AzureLocation)
+	}
+	if tag := group.Tags["env"]; tag == nil || *tag != "test" {
+		t.Errorf("tag env = %v, want test", tag)
+	}
+}
+
+func TestCreateResourceGroupError(t *testing.T) {
+	groups := &fakeResourceGroups{createErr: responseError(http.StatusForbidden)}
+	useFakeClients(t, groups, &fakeStorageAccounts{}, &fakeResources{})
+
+	if _, err := createResourceGroup(context.Background(), testConfig()); err == nil {
+		t.Fatal("createResourceGroup succeeded, want the CreateOrUpdate error")
+	}
+}
+
+func TestCreateResourceGroupReuse(t *testing.T) {
+	existing := armresources.ResourceGroup{
+		ID:       to.Ptr("/subscriptions/sub/resourceGroups/rg-test"),
+		Name:     to.Ptr("rg-test"),
+		Location: to.Ptr("westeurope"),
+	}
+	tests := []struct {
+		mode        string
+		groups      map[string]armresources.ResourceGroup
+		wantErr     bool
+		wantCreated bool
+	}{
+		{reuseGroupRequired, map[string]armresources.ResourceGroup{"rg-test": existing}, false, false},
+		{reuseGroupRequired, nil, true, false},
+		{reuseGroupCreate, map[string]armresources.ResourceGroup{"rg-test": existing}, false, false},
+		{reuseGroupCreate, nil, false, true},
+	}
+	for _, tt := range tests {
+		groups := &fakeResourceGroups{groups: tt.groups}
+		useFakeClients(t, groups, &fakeStorageAccounts{}, &fakeResources{})
+		cfg := testConfig()
+		cfg.ReuseResourceGroup = tt.mode
+
+		_, err := createResourceGroup(context.Background(), cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("REUSE_RESOURCE_GROUP=%s with %d groups: error %v, want error %t", tt.mode, len(tt.groups), err, tt.wantErr)
+		}
+		if created := len(groups.created) > 0; created != tt.wantCreated {
+			t.Errorf("REUSE_RESOURCE_GROUP=%s with %d groups: created = %t, want %t", tt.mode, len(tt.groups), created, tt.wantCreated)
+		}
+	}
+}
+
+func TestCreateResourceGroupReuseOtherLocation(t *testing.T) {
+	groups := &fakeResourceGroups{groups: map[string]armresources.ResourceGroup{
+		"rg-test": {Name: to.Ptr("rg-test"), Location: to.Ptr("eastus")},
+	}}
+	useFakeClients(t, groups, &fakeStorageAccounts{}, &fakeResources{})
+	cfg := testConfig()
+	cfg.ReuseResourceGroup = reuseGroupCreate
+
+	if _, err := createResourceGroup(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "eastus") {
+		t.Fatalf("createResourceGroup error = %v, want the location mismatch", err)
+	}
+}
+
+func TestProvisionStorage(t *testing.T) {
+	accounts := &fakeStorageAccounts{}
+	useFakeClients(t, &fakeResourceGroups{}, accounts, &fakeResources{})
+	cfg := testConfig()
+
+	steps := stepTracker{parent: context.Background(), timeout: cfg.StepTimeout}
+	var rollback rollbackStack
+	var result DeploymentResult
+	if err := provisionStorage(&steps, cfg, &rollback, &result); err != nil {
+		t.Fatalf("provisionStorage: %v", err)
+	}
+	steps.finish(nil)
+
+	if accounts.createCalls != 1 {
+		t.Errorf("BeginCreate called %d times, want 1", accounts.createCalls)
+	}
+	if !strings.HasSuffix(result.StorageAccountID, "/storageAccounts/"+cfg.AzureStorageAccountName) {
+		t.Errorf("StorageAccountID = %q, want the created account", result.StorageAccountID)
+	}
+	if len(rollback.actions) != 1 {
+		t.Errorf("%d rollback actions registered, want 1 for the new account", len(rollback.actions))
+	}
+}
+
+func TestProvisionStorageNameUnavailable(t *testing.T) {
+	accounts := &fakeStorageAccounts{taken: true}
+	useFakeClients(t, &fakeResourceGroups{}, accounts, &fakeResources{})
+	cfg := testConfig()
+
+	steps := stepTracker{parent: context.Background(), timeout: cfg.StepTimeout}
+	var rollback rollbackStack
+	err := provisionStorage(&steps, cfg, &rollback, &DeploymentResult{})
+	steps.finish(err)
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != StepCheckStorageName {
+		t.Fatalf("provisionStorage error = %v, want a %q step error", err, StepCheckStorageName)
+	}
+	if !strings.Contains(err.Error(), "not available") {
+		t.Errorf("error %q does not report the name as unavailable", err)
+	}
+	if accounts.createCalls != 0 {
+		t.Errorf("BeginCreate called %d times, want 0", accounts.createCalls)
+	}
+}
+
+func TestProvisionStorageTransientError(t *testing.T) {
+	accounts := &fakeStorageAccounts{checkErrs: []error{responseError(http.StatusServiceUnavailable), responseError(http.StatusTooManyRequests)}}
+	useFakeClients(t, &fakeResourceGroups{}, accounts, &fakeResources{})
+	cfg := testConfig()
+
+	steps := stepTracker{parent: context.Background(), timeout: cfg.StepTimeout}
+	var rollback rollbackStack
+	err := provisionStorage(&steps, cfg, &rollback, &DeploymentResult{})
+	steps.finish(err)
+	if err != nil {
+		t.Fatalf("provisionStorage: %v, want the transient errors retried", err)
+	}
+	if accounts.checkCalls != 3 {
+		t.Errorf("CheckNameAvailability called %d times, want 3", accounts.checkCalls)
+	}
+}
+
+func TestProvisionStorageTransientErrorExhausted(t *testing.T) {
+	transient := responseError(http.StatusServiceUnavailable)
+	accounts := &fakeStorageAccounts{checkErrs: []error{transient, transient, transient}}
+	useFakeClients(t, &fakeResourceGroups{}, accounts, &fakeResources{})
+	cfg := testConfig()
+
+	steps := stepTracker{parent: context.Background(), timeout: cfg.StepTimeout}
+	var rollback rollbackStack
+	err := provisionStorage(&steps, cfg, &rollback, &DeploymentResult{})
+	steps.finish(err)
+
+	if err == nil || !strings.Contains(err.Error(), "failed after 2 retries") {
+		t.Fatalf("provisionStorage error = %v, want it to give up after MAX_RETRIES", err)
+	}
+	if accounts.createCalls != 0 {
+		t.Errorf("BeginCreate called %d times, want 0", accounts.createCalls)
+	}
+}
+
+func TestFunctionAppExists(t *testing.T) {
+	cfg := testConfig()
+	useFakeClients(t, &fakeResourceGroups{}, &fakeStorageAccounts{}, &fakeResources{existing: []string{functionAppID(cfg)}})
+
+	exists, err := functionAppExists(context.Background(), cfg)
+	if err != nil || !exists {
+		t.Fatalf("functionAppExists = %t, %v, want true", exists, err)
+	}
+	cfg.AzureFunctionAppName = "func-other"
+	exists, err = functionAppExists(context.Background(), cfg)
+	if err != nil || exists {
+		t.Fatalf("functionAppExists = %t, %v, want false", exists, err)
+	}
+}
+
+func TestCreateFunctionApp(t *testing.T) {
+	runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+		return []byte(`{"defaultHostName":"func-test.azurewebsites.net","identity":{"principalId":"principal"}}`), nil
+	}}
+	cfg := testConfig()
+	cfg.CommandRunner = runner
+	cfg.AssignIdentity = true
+	cfg.UserAssignedIdentityID = "/subscriptions/sub/resourceGroups/rg-test/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id-test"
+
+	site, err := createFunctionApp(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("createFunctionApp: %v", err)
+	}
+	if site.DefaultHostName != "func-test.azurewebsites.net" || site.Identity == nil || site.Identity.PrincipalID != "principal" {
+		t.Errorf("site = %+v, want the host name and principal ID from the az output", site)
+	}
+	if len(runner.calls) != 1 {
+		t.Fatalf("%d commands run, want 1", len(runner.calls))
+	}
+	call := runner.calls[0]
+	if call.name != "az" || !slices.Equal(call.args[:2], []string{"functionapp", "create"}) {
+		t.Errorf("ran %s %v, want az functionapp create", call.name, call.args)
+	}
+	if i := slices.Index(call.args, "--assign-identity"); i < 0 || !slices.Equal(call.args[i+1:i+3], []string{"[system]", cfg.UserAssignedIdentityID}) {
+		t.Errorf("args %v do not assign the system and user-assigned identities", call.args)
+	}
+}
+
+func TestCreateFunctionAppCommandError(t *testing.T) {
+	runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+		return []byte("ERROR: the app name is already in use"), errors.New("exit status 1")
+	}}
+	cfg := testConfig()
+	cfg.CommandRunner = runner
+
+	_, err := createFunctionApp(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Fatalf("createFunctionApp error = %v, want the az output", err)
+	}
+}
+
+func TestCheckStorageQuota(t *testing.T) {
+	tests := []struct {
+		current, limit int32
+		existing       bool
+		wantErr        bool
+	}{
+		{10, 250, false, false},
+		{249, 250, false, false},
+		{250, 250, false, true},
+		{250, 250, true, false},
+		{0, 0, false, false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		accounts := &fakeStorageAccounts{}
+		if tt.existing {
+			accounts.accounts = map[string]armstorage.Account{cfg.AzureStorageAccountName: {ID: to.Ptr("/subscriptions/sub/storageAccounts/" + cfg.AzureStorageAccountName)}}
+		}
+		useFakeClients(t, &fakeResourceGroups{}, accounts, &fakeResources{})
+		usagesClient = &fakeUsages{current: tt.current, limit: tt.limit}
+
+		err := checkStorageQuota(context.Background(), cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d of %d used, existing account %t: error %v, want error %t", tt.current, tt.limit, tt.existing, err, tt.wantErr)
+		}
+	}
+}
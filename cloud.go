@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// azureCloud describes an Azure cloud environment for both the SDK and the az CLI
+type azureCloud struct {
+	config    cloud.Configuration
+	cliName   string // name accepted by `az cloud set --name`
+	scmSuffix string // host suffix of Function App Kudu (SCM) sites
+}
+
+// defaultAzureCloud is used when AZURE_CLOUD is not set
+const defaultAzureCloud = "public"
+
+// azureClouds maps the accepted AZURE_CLOUD values to their environments
+var azureClouds = map[string]azureCloud{
+	"public": {config: cloud.AzurePublic, cliName: "AzureCloud", scmSuffix: "scm.azurewebsites.net"},
+	"usgov":  {config: cloud.AzureGovernment, cliName: "AzureUSGovernment", scmSuffix: "scm.azurewebsites.us"},
+	"china":  {config: cloud.AzureChina, cliName: "AzureChinaCloud", scmSuffix: "scm.chinacloudsites.cn"},
+}
+
+// lookupAzureCloud returns the environment for an AZURE_CLOUD value
+func lookupAzureCloud(name string) (azureCloud, error) {
+	c, ok := azureClouds[strings.ToLower(name)]
+	if !ok {
+		return azureCloud{}, fmt.Errorf("unknown Azure cloud %q, accepted values are: %s",
+			name, strings.Join(slices.Sorted(maps.Keys(azureClouds)), ", "))
+	}
+	return c, nil
+}
+
+// clientOptions returns the azcore client options targeting the configured cloud
+func clientOptions(cfg Config) azcore.ClientOptions {
+	c, _ := lookupAzureCloud(cfg.AzureCloud)
+	return azcore.ClientOptions{Cloud: c.config}
+}
+
+// armClientOptions returns the ARM client options targeting the configured cloud
+func armClientOptions(cfg Config) *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: clientOptions(cfg)}
+}
+
+// resourceManagerScope returns the token scope of the configured cloud's Resource Manager
+func resourceManagerScope(cfg Config) string {
+	c, _ := lookupAzureCloud(cfg.AzureCloud)
+	return c.config.Services[cloud.ResourceManager].Audience + "/.default"
+}
+
+// cliCloudMu serializes setCLICloud, as concurrent deployments share the az CLI
+// configuration
+var cliCloudMu sync.Mutex
+
+// setCLICloud points the az CLI at the configured cloud. `az cloud set` changes the
+// active cloud of the whole az CLI installation, so it only runs when `az cloud show`
+// reports another cloud. The lookup is read-only, so it also runs in dry-run mode
+func setCLICloud(ctx context.Context, cfg Config) error {
+	c, err := lookupAzureCloud(cfg.AzureCloud)
+	if err != nil {
+		return err
+	}
+
+	cliCloudMu.Lock()
+	defer cliCloudMu.Unlock()
+
+	output, err := runCommand(ctx, cfg, "", "az", "cloud", "show", "--query", "name", "--output", "tsv")
+	if err != nil {
+		return fmt.Errorf("az cloud show failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	current := strings.TrimSpace(string(output))
+	if current == c.cliName {
+		log.Println("Azure CLI already uses cloud", c.cliName)
+		return nil
+	}
+
+	if cfg.DryRun {
+		planDryRun("switch the az CLI from cloud %s to %s (az cloud set --name %s)", current, c.cliName, c.cliName)
+		return nil
+	}
+
+	output, err = runCommand(ctx, cfg, "", "az", "cloud", "set", "--name", c.cliName)
+	if err != nil {
+		return fmt.Errorf("az cloud set failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	log.Printf("Warning: switched the az CLI from cloud %s to %s, this changes the active cloud of the az CLI installation", current, c.cliName)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSetCLICloud(t *testing.T) {
+	tests := []struct {
+		cloud   string
+		current string
+		wantSet bool
+	}{
+		{"public", "AzureCloud", false},
+		{"usgov", "AzureUSGovernment", false},
+		{"usgov", "AzureCloud", true},
+	}
+	for _, tt := range tests {
+		runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+			if slices.Contains(call.args, "show") {
+				return []byte(tt.current + "\n"), nil
+			}
+			return nil, nil
+		}}
+		cfg := testConfig()
+		cfg.AzureCloud = tt.cloud
+		cfg.CommandRunner = runner
+
+		if err := setCLICloud(context.Background(), cfg); err != nil {
+			t.Fatalf("setCLICloud(%s): %v", tt.cloud, err)
+		}
+		set := slices.ContainsFunc(runner.calls, func(call commandCall) bool { return slices.Contains(call.args, "set") })
+		if set != tt.wantSet {
+			t.Errorf("AZURE_CLOUD %s with the az CLI on %s: ran az cloud set = %t, want %t", tt.cloud, tt.current, set, tt.wantSet)
+		}
+	}
+}
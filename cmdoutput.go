@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// outputTailLines is the number of trailing CLI output lines included in error messages
+const outputTailLines = 20
+
+// lineLogger is an io.Writer that logs each complete line written to it, prefixed with
+// the command name, so CLI output is streamed through the logger as it is produced
+type lineLogger struct {
+	prefix  string
+	mu      sync.Mutex
+	pending []byte
+}
+
+// newLineLogger returns a lineLogger prefixing lines with the base name of command
+func newLineLogger(command string) *lineLogger {
+	return &lineLogger{prefix: filepath.Base(command)}
+}
+
+// Write implements io.Writer
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = append(l.pending, p...)
+	for {
+		i := strings.IndexByte(string(l.pending), '\n')
+		if i < 0 {
+			break
+		}
+		l.logLine(string(l.pending[:i]))
+		l.pending = l.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs a final line that was not terminated by a newline, such as a prompt
+func (l *lineLogger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) > 0 {
+		l.logLine(string(l.pending))
+		l.pending = nil
+	}
+}
+
+// logLine logs one line of output, dropping carriage returns used by progress bars
+func (l *lineLogger) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	log.Printf("[%s] %s", l.prefix, line)
+}
+
+// outputTail returns the last outputTailLines lines of CLI output for error messages,
+// noting how many earlier lines were left out
+func outputTail(output []byte) string {
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) <= outputTailLines {
+		return strings.Join(lines, "\n")
+	}
+	omitted := len(lines) - outputTailLines
+	return fmt.Sprintf("... (%d earlier lines omitted)\n%s", omitted, strings.Join(lines[omitted:], "\n"))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configKeys lists every setting read by loadConfig. Keys in a config file must use the
+// same names as the environment variables; keep this list in sync with loadConfig
+var configKeys = []string{
+	"AZURE_SUBSCRIPTION_ID",
+	"AZURE_LOCATION",
+	"AZURE_RESOURCE_GROUP_NAME",
+	"AZURE_STORAGE_ACCOUNT_NAME",
+	"AZURE_FUNCTION_APP_NAME",
+	"FUNCTION_NAME",
+	"FUNCTIONS",
+	"DEPLOYMENTS",
+	"DEPLOY_CONCURRENCY",
+	"FUNCTION_TEMPLATE",
+	"AUTH_LEVEL",
+	"SKIP_TEMPLATE_VALIDATION",
+	"KEEP_RESOURCE",
+	"FUNCTION_PROJECT_DIR",
+	"DRY_RUN",
+	"STORAGE_SKU",
+	"ACCESS_TIER",
+	"STORAGE_KIND",
+	"STORAGE_PREMIUM",
+	"MIN_TLS_VERSION",
+	"HTTPS_ONLY",
+	"BLOB_SOFT_DELETE_DAYS",
+	"BLOB_VERSIONING",
+	"ENABLE_HIERARCHICAL_NAMESPACE",
+	"ENABLE_SFTP",
+	"ENABLE_LARGE_FILE_SHARES",
+	"ENCRYPTION_KEY_SOURCE",
+	"ENCRYPTION_KEY_VAULT_URI",
+	"ENCRYPTION_IDENTITY_ID",
+	"ENCRYPTION_SERVICES",
+	"NETWORK_DEFAULT_ACTION",
+	"ALLOWED_IP_RANGES",
+	"ALLOWED_SUBNET_IDS",
+	"BLOB_CONTAINERS",
+	"BLOB_CONTAINER_PUBLIC_ACCESS",
+	"FUNCTION_RUNTIME",
+	"FUNCTION_RUNTIME_VERSION",
+	"OS_TYPE",
+	"FUNC_PROGRAMMING_MODEL",
+	"FUNC_LANGUAGE",
+	"CONTAINER_IMAGE",
+	"REGISTRY_SERVER",
+	"REGISTRY_USERNAME",
+	"REGISTRY_PASSWORD",
+	"FUNCTIONS_VERSION",
+	"PUBLISH_MODE",
+	"SKIP_PUBLISH",
+	"SKIP_INFRA",
+	"UPDATE_RUNTIME_VERSION",
+	"IF_APP_EXISTS",
+	"PARALLEL",
+	"CHECK_QUOTA",
+	"VERIFY_DEPLOYMENT",
+	"VERIFY_TIMEOUT",
+	"RESOURCE_TAGS",
+	"REUSE_RESOURCE_GROUP",
+	"USE_EXISTING_RESOURCE_GROUP",
+	"USE_EXISTING_STORAGE_ACCOUNT",
+	"STORAGE_RESOURCE_GROUP",
+	"ROLLBACK_ON_FAILURE",
+	"AZ_PATH",
+	"FUNC_PATH",
+	"MIN_AZ_VERSION",
+	"MIN_FUNC_VERSION",
+	"CLI_TIMEOUT",
+	"PLAN_TYPE",
+	"PLAN_SKU",
+	"PLAN_NAME",
+	"MODE",
+	"AUTO_APPROVE",
+	"CONFIRM_DELETE",
+	"APP_SETTINGS",
+	"CORS_ORIGINS",
+	"APP_SETTINGS_FILE",
+	"ENABLE_APP_INSIGHTS",
+	"ASSIGN_IDENTITY",
+	"USER_ASSIGNED_IDENTITY_ID",
+	"APP_INSIGHTS_NAME",
+	"LOG_FORMAT",
+	"VERBOSE",
+	"STEP_TIMEOUT",
+	"DEPLOY_TIMEOUT",
+	"MAX_RETRIES",
+	"RETRY_BASE_DELAY",
+	"PROGRESS_INTERVAL",
+	"POLL_FREQUENCY",
+	"OUTPUT_FILE",
+	"SHOW_SECRETS",
+	"EXPORT_CONNECTION_STRING",
+	"CONNECTION_STRING_OUTPUT",
+	"AZURE_CLOUD",
+	"AUTH_METHOD",
+	"AZURE_CLIENT_ID",
+	"AZURE_CLIENT_SECRET",
+	"AZURE_TENANT_ID",
+}
+
+// configPrecedence explains which source wins when a setting is defined more than once
+const configPrecedence = "precedence: environment variables, then .env, then the config file"
+
+// loadConfigFile reads a YAML or JSON config file, chosen by its extension, and exports
+// each setting as an environment variable unless it is already set, so that the
+// environment and .env file override values from the file. Unknown keys are logged
+// as warnings
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if !slices.Contains(configKeys, key) {
+			log.Printf("Warning: ignoring unknown key %q in config file %s", key, path)
+			continue
+		}
+
+		var value string
+		if key == "FUNCTIONS" || key == "DEPLOYMENTS" {
+			// These manifests are lists of maps, which the generic conversion would flatten
+			value, err = manifestString(values[key])
+		} else {
+			value, err = configValueString(values[key])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %v", key, path, err)
+		}
+
+		if existing, ok := os.LookupEnv(key); ok && existing != value {
+			log.Printf("%s from the environment overrides the config file (%s)", key, configPrecedence)
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Config file loaded successfully:", path)
+	return nil
+}
+
+// configValueString converts a config file value to its environment variable form.
+// Lists are joined with commas and maps are rendered as key=value pairs, matching the
+// format of settings such as RESOURCE_TAGS
+func configValueString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := []string{}
+		for _, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := []string{}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			s, err := configValueString(v[key])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// manifestString converts the FUNCTIONS or DEPLOYMENTS list of a config file to the
+// JSON form read from the environment. A string is assumed to already be JSON
+func manifestString(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
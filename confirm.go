@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// promptMu serializes the prompts of concurrent deployments
+var promptMu sync.Mutex
+
+// confirmDeletion asks the user to confirm deleting the configured resource group by
+// typing its name or "yes". The prompt is skipped, and deletion approved, with
+// AUTO_APPROVE or in dry-run mode. When stdin is not a terminal no prompt is possible,
+// so deletion is approved only if requireApproval is false
+func confirmDeletion(cfg Config, requireApproval bool) (bool, error) {
+	if cfg.AutoApprove || cfg.DryRun {
+		return true, nil
+	}
+	if !stdinIsTerminal() {
+		if requireApproval {
+			return false, fmt.Errorf("refusing to delete resource group %s without confirmation, re-run with --yes or AUTO_APPROVE=true",
+				cfg.AzureResourceGroupName)
+		}
+		return true, nil
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fmt.Printf("Delete resource group %s and all resources in it? Type the resource group name or 'yes' to confirm: ", cfg.AzureResourceGroupName)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, fmt.Errorf("failed to read confirmation: %v", err)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == cfg.AzureResourceGroupName || strings.EqualFold(answer, "yes") {
+		return true, nil
+	}
+	log.Printf("Deletion of resource group %s was not confirmed.", cfg.AzureResourceGroupName)
+	return false, nil
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
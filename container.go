@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// containerFunctionsVersion is the only Functions runtime version custom container
+// images are built for
+const containerFunctionsVersion = "4"
+
+// validateContainer checks the CONTAINER_IMAGE and REGISTRY_* settings. Custom
+// containers only run on Linux premium or dedicated plans, and the image already holds
+// the code, so there is nothing for SKIP_INFRA to publish
+func validateContainer(cfg Config) error {
+	if cfg.ContainerImage == "" {
+		if cfg.RegistryServer != "" || cfg.RegistryUsername != "" || cfg.RegistryPassword != "" {
+			return errors.New("REGISTRY_SERVER, REGISTRY_USERNAME and REGISTRY_PASSWORD require CONTAINER_IMAGE")
+		}
+		return nil
+	}
+	if cfg.AzureOSType == osTypeWindows {
+		return fmt.Errorf("CONTAINER_IMAGE requires a Linux plan, unset OS_TYPE or set it to %s", osTypeLinux)
+	}
+	if cfg.PlanType == planTypeConsumption {
+		return fmt.Errorf("CONTAINER_IMAGE requires a Linux %s or %s plan, set PLAN_TYPE accordingly", planTypePremium, planTypeDedicated)
+	}
+	if cfg.FunctionsVersion != containerFunctionsVersion {
+		return fmt.Errorf("CONTAINER_IMAGE requires FUNCTIONS_VERSION %s, got %s", containerFunctionsVersion, cfg.FunctionsVersion)
+	}
+	if cfg.SkipInfra {
+		return errors.New("CONTAINER_IMAGE deploys the code with the image, SKIP_INFRA has nothing to publish")
+	}
+	if (cfg.RegistryUsername == "") != (cfg.RegistryPassword == "") {
+		return errors.New("REGISTRY_USERNAME and REGISTRY_PASSWORD must be set together")
+	}
+	return nil
+}
+
+// containerArgs returns the `az functionapp create` flags deploying CONTAINER_IMAGE,
+// pulled with the REGISTRY_* credentials when set, in place of the runtime flags
+func containerArgs(cfg Config) []string {
+	args := []string{"--deployment-container-image-name", cfg.ContainerImage}
+	if cfg.RegistryServer != "" {
+		args = append(args, "--registry-server", cfg.RegistryServer)
+	}
+	if cfg.RegistryUsername != "" {
+		args = append(args, "--registry-username", cfg.RegistryUsername, "--registry-password", cfg.RegistryPassword)
+	}
+	return args
+}
+
+// maskRegistryPassword returns a copy of the az arguments with the registry password
+// masked, for logging
+func maskRegistryPassword(args []string) []string {
+	masked := slices.Clone(args)
+	if i := slices.Index(masked, "--registry-password"); i >= 0 && i+1 < len(masked) {
+		masked[i+1] = "****"
+	}
+	return masked
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// containerNamePattern matches valid blob container names: 3-63 lowercase letters,
+// digits and single hyphens, starting and ending with a letter or digit
+var containerNamePattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9]|-[a-z0-9]){2,62}$`)
+
+// parseBlobContainers parses the comma-separated BLOB_CONTAINERS list, validating each
+// name against the Azure naming rules
+func parseBlobContainers(value string) ([]string, error) {
+	names := []string{}
+	for _, name := range splitList(value) {
+		if !containerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid container name %q: use 3-63 lowercase letters, digits and single hyphens, starting and ending with a letter or digit", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// parsePublicAccess maps a BLOB_CONTAINER_PUBLIC_ACCESS value of None, Blob or Container
+// to its armstorage.PublicAccess
+func parsePublicAccess(value string) (armstorage.PublicAccess, error) {
+	for _, access := range armstorage.PossiblePublicAccessValues() {
+		if strings.EqualFold(value, string(access)) {
+			return access, nil
+		}
+	}
+	return "", fmt.Errorf("unknown public access level %q, accepted values are: None, Blob, Container", value)
+}
+
+// createBlobContainers creates the configured blob containers that do not exist yet and
+// returns the IDs of the containers it created
+func createBlobContainers(ctx context.Context, cfg Config) ([]string, error) {
+	names, err := parseBlobContainers(cfg.BlobContainers)
+	if err != nil {
+		return nil, err
+	}
+	access, err := parsePublicAccess(cfg.BlobContainerPublicAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []string{}
+	for _, name := range names {
+		if cfg.DryRun {
+			planDryRun("create blob container %s in storage account %s (public access=%s)", name, cfg.AzureStorageAccountName, access)
+			created = append(created, blobContainerID(cfg, name))
+			continue
+		}
+
+		_, err := blobContainersClient.Get(ctx, storageResourceGroup(cfg), cfg.AzureStorageAccountName, name, nil)
+		if err == nil {
+			log.Println("Blob container already exists, skipping:", name)
+			continue
+		}
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusNotFound {
+			return created, fmt.Errorf("failed to look up blob container %s: %w", name, err)
+		}
+
+		resp, err := blobContainersClient.Create(ctx, storageResourceGroup(cfg), cfg.AzureStorageAccountName, name,
+			armstorage.BlobContainer{
+				ContainerProperties: &armstorage.ContainerProperties{PublicAccess: to.Ptr(access)},
+			}, nil)
+		if err != nil {
+			return created, fmt.Errorf("failed to create blob container %s: %w", name, err)
+		}
+		log.Println("Blob container created:", name)
+		created = append(created, derefString(resp.ID))
+	}
+	return created, nil
+}
+
+// blobContainerID builds the Azure resource ID of a blob container in the configured
+// Storage Account
+func blobContainerID(cfg Config, name string) string {
+	return fmt.Sprintf("%s/blobServices/default/containers/%s", storageAccountID(cfg), name)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// corsWildcard allows requests from any origin
+const corsWildcard = "*"
+
+// parseCORSOrigins parses the comma-separated CORS_ORIGINS list. Each entry is either
+// the * wildcard or an http(s) origin such as https://app.example.com, without a path
+func parseCORSOrigins(value string) ([]string, error) {
+	origins := []string{}
+	for _, origin := range splitList(value) {
+		origin = strings.TrimSuffix(origin, "/")
+		if origin != corsWildcard {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("invalid origin %q, expected * or a scheme and host such as https://app.example.com", origin)
+			}
+		}
+		if !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	return origins, nil
+}
+
+// validateCORS checks CORS_ORIGINS and warns about the * wildcard, which Azure does not
+// allow together with credentialed requests
+func validateCORS(cfg Config) error {
+	origins, err := parseCORSOrigins(cfg.AllowedOrigins)
+	if err != nil {
+		return fmt.Errorf("invalid CORS_ORIGINS: %w", err)
+	}
+	if slices.Contains(origins, corsWildcard) {
+		log.Println("Warning: CORS_ORIGINS contains *, any website can call the functions and credentialed (cookie or Authorization) requests are not allowed")
+		if len(origins) > 1 {
+			log.Println("Warning: CORS_ORIGINS contains *, the other origins listed are redundant")
+		}
+	}
+	return nil
+}
+
+// configureCORS adds the CORS_ORIGINS to the allowed origins of the Function App using
+// `az functionapp cors add`. Origins already allowed are kept
+func configureCORS(ctx context.Context, cfg Config, origins []string) error {
+	cmdArgs := []string{
+		"functionapp", "cors", "add",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.AzureFunctionAppName,
+		"--allowed-origins",
+	}
+	cmdArgs = append(cmdArgs, origins...)
+
+	if cfg.DryRun {
+		planDryRun("allow CORS origins on Function App %s (az %s)", cfg.AzureFunctionAppName, strings.Join(cmdArgs, " "))
+		return nil
+	}
+
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
+	if err != nil {
+		return fmt.Errorf("az functionapp cors add failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	log.Println("CORS allowed origins configured:", strings.Join(origins, ", "))
+	return nil
+}
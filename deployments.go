@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultDeployConcurrency is the number of DEPLOYMENTS entries deployed at the same
+// time when DEPLOY_CONCURRENCY is not set
+const defaultDeployConcurrency = 4
+
+// deploymentOverrides lists the settings a DEPLOYMENTS entry may set. Every other
+// setting is shared by all deployments
+var deploymentOverrides = []string{
+	"AZURE_RESOURCE_GROUP_NAME",
+	"AZURE_STORAGE_ACCOUNT_NAME",
+	"AZURE_FUNCTION_APP_NAME",
+	"AZURE_LOCATION",
+	"FUNCTION_PROJECT_DIR",
+	"PLAN_NAME",
+	"APP_INSIGHTS_NAME",
+}
+
+// parseDeployments parses DEPLOYMENTS, a JSON array of objects each overriding the
+// resource names of one independent deployment, and returns the configuration of each.
+// In a config file it is written as a list of maps. It returns nil when DEPLOYMENTS is
+// not set
+func parseDeployments(cfg Config) ([]Config, error) {
+	if cfg.Deployments == "" {
+		return nil, nil
+	}
+
+	entries := []map[string]string{}
+	if err := json.Unmarshal([]byte(cfg.Deployments), &entries); err != nil {
+		return nil, fmt.Errorf("invalid DEPLOYMENTS, expected a list of objects of string settings: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("DEPLOYMENTS must list at least one deployment")
+	}
+
+	targets := []Config{}
+	for i, entry := range entries {
+		target := cfg
+		target.Deployments = ""
+		// The combined results are written by DeployAll
+		target.OutputFile = ""
+		for key, value := range entry {
+			if !slices.Contains(deploymentOverrides, key) {
+				return nil, fmt.Errorf("DEPLOYMENTS entry %d sets %s, accepted settings are: %s",
+					i+1, key, strings.Join(deploymentOverrides, ", "))
+			}
+			applyDeploymentOverride(&target, cfg, key, value)
+		}
+		for _, key := range deploymentOverrides[:3] {
+			if entry[key] == "" {
+				return nil, fmt.Errorf("DEPLOYMENTS entry %d must set %s", i+1, key)
+			}
+		}
+		// Check every entry before any deployment starts, rather than failing one
+		// deployment while the others proceed
+		if err := validateNames(target); err != nil {
+			return nil, fmt.Errorf("DEPLOYMENTS entry %d: %w", i+1, err)
+		}
+		targets = append(targets, target)
+	}
+
+	// Concurrent deployments must not share resources or a project directory, except an
+	// existing storage account that is only read
+	for i, a := range targets {
+		for _, b := range targets[:i] {
+			switch {
+			case strings.EqualFold(a.AzureResourceGroupName, b.AzureResourceGroupName):
+				return nil, fmt.Errorf("DEPLOYMENTS entries share resource group %s", a.AzureResourceGroupName)
+			case a.AzureStorageAccountName == b.AzureStorageAccountName && !cfg.UseExistingStorageAccount:
+				return nil, fmt.Errorf("DEPLOYMENTS entries share storage account %s", a.AzureStorageAccountName)
+			case strings.EqualFold(a.AzureFunctionAppName, b.AzureFunctionAppName):
+				return nil, fmt.Errorf("DEPLOYMENTS entries share Function App %s", a.AzureFunctionAppName)
+			case filepath.Clean(a.FunctionProjectDir) == filepath.Clean(b.FunctionProjectDir) && cfg.DeployConcurrency > 1:
+				return nil, fmt.Errorf("DEPLOYMENTS entries for %s and %s share FUNCTION_PROJECT_DIR %s, give each its own directory or set DEPLOY_CONCURRENCY=1",
+					b.AzureFunctionAppName, a.AzureFunctionAppName, a.FunctionProjectDir)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// applyDeploymentOverride sets one DEPLOYMENTS setting on target. The plan and
+// Application Insights names follow the Function App name unless they were set
+// explicitly
+func applyDeploymentOverride(target *Config, base Config, key, value string) {
+	switch key {
+	case "AZURE_RESOURCE_GROUP_NAME":
+		target.AzureResourceGroupName = value
+	case "AZURE_STORAGE_ACCOUNT_NAME":
+		target.AzureStorageAccountName = value
+	case "AZURE_FUNCTION_APP_NAME":
+		target.AzureFunctionAppName = value
+		if target.PlanName == base.AzureFunctionAppName+"-plan" {
+			target.PlanName = value + "-plan"
+		}
+		if target.AppInsightsName == base.AzureFunctionAppName+"-insights" {
+			target.AppInsightsName = value + "-insights"
+		}
+	case "AZURE_LOCATION":
+		target.AzureLocation = value
+	case "FUNCTION_PROJECT_DIR":
+		target.FunctionProjectDir = value
+	case "PLAN_NAME":
+		target.PlanName = value
+	case "APP_INSIGHTS_NAME":
+		target.AppInsightsName = value
+	}
+}
+
+// DeployAll runs Deploy once when DEPLOYMENTS is not set. Otherwise it deploys every
+// DEPLOYMENTS entry, at most DEPLOY_CONCURRENCY at a time. A failing deployment does not
+// stop the others; their errors are joined and returned once all have finished. The
+// whole run is cancelled when DEPLOY_TIMEOUT is set and exceeded
+func DeployAll(ctx context.Context, config Config) ([]DeploymentResult, error) {
+	if config.DeployTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.DeployTimeout)
+		defer cancel()
+	}
+
+	targets, err := parseDeployments(config)
+	if err != nil {
+		return nil, &StepError{Step: StepValidateConfig, Err: err}
+	}
+	if targets == nil {
+		result, err := Deploy(ctx, config)
+		if err != nil {
+			return []DeploymentResult{result}, err
+		}
+		if config.DryRun {
+			logDryRunSummary()
+		}
+		return []DeploymentResult{result}, nil
+	}
+	if config.DeployConcurrency < 1 {
+		return nil, &StepError{Step: StepValidateConfig, Err: fmt.Errorf("invalid DEPLOY_CONCURRENCY %d, must be at least 1", config.DeployConcurrency)}
+	}
+
+	log.Printf("Deploying %d Function Apps, %d at a time", len(targets), config.DeployConcurrency)
+	results := make([]DeploymentResult, len(targets))
+	errs := make([]error, len(targets))
+	var g errgroup.Group
+	g.SetLimit(config.DeployConcurrency)
+	for i, target := range targets {
+		g.Go(func() error {
+			results[i], errs[i] = Deploy(ctx, target)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("deployment of %s: %w", target.AzureFunctionAppName, errs[i])
+				log.Println(errs[i])
+			} else {
+				log.Println("Deployment succeeded:", target.AzureFunctionAppName)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return results, err
+	}
+	if config.OutputFile != "" {
+		if err := writeDeploymentResult(config.OutputFile, results); err != nil {
+			return results, &StepError{Step: StepWriteResult, Err: err}
+		}
+		log.Println("Deployment results written to", config.OutputFile)
+	}
+	if config.DryRun {
+		logDryRunSummary()
+	}
+	return results, nil
+}
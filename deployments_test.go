@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDeploymentsUseTheirOwnProjectDirectory(t *testing.T) {
+	root := t.TempDir()
+	dirs := map[string]string{
+		"func-a": filepath.Join(root, "a"),
+		"func-b": filepath.Join(root, "b"),
+	}
+	entries := []map[string]string{}
+	for i, app := range []string{"func-a", "func-b"} {
+		entries = append(entries, map[string]string{
+			"AZURE_RESOURCE_GROUP_NAME":  fmt.Sprintf("rg-%d", i),
+			"AZURE_STORAGE_ACCOUNT_NAME": fmt.Sprintf("st%d", i),
+			"AZURE_FUNCTION_APP_NAME":    app,
+			"FUNCTION_PROJECT_DIR":       dirs[app],
+		})
+	}
+	deployments, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.FunctionName = "HttpTrigger"
+	cfg.FunctionTemplate = "HTTP trigger"
+	cfg.AuthLevel = "function"
+	cfg.Deployments = string(deployments)
+	cfg.DeployConcurrency = 2
+	targets, err := parseDeployments(cfg)
+	if err != nil {
+		t.Fatalf("parseDeployments: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deploy the projects concurrently, each with a runner checking that every func
+	// command runs in that deployment's directory
+	runners := make([]*fakeCommandRunner, len(targets))
+	var g errgroup.Group
+	for i, target := range targets {
+		want := dirs[target.AzureFunctionAppName]
+		runners[i] = &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+			if call.dir != want {
+				t.Errorf("%s: func %v ran in %q, want %q", target.AzureFunctionAppName, call.args, call.dir, want)
+			}
+			return nil, nil
+		}}
+		target.CommandRunner = runners[i]
+		g.Go(func() error {
+			steps := stepTracker{parent: context.Background(), timeout: target.StepTimeout}
+			var result DeploymentResult
+			err := prepareFunctionProject(&steps, target, &result)
+			if err == nil {
+				err = publishFunctions(&steps, target, nil, &result)
+			}
+			steps.finish(err)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("deployment failed: %v", err)
+	}
+
+	// func init, func new and func azure functionapp publish
+	for i, runner := range runners {
+		if len(runner.calls) != 3 {
+			t.Errorf("%s: %d commands run, want 3", targets[i].AzureFunctionAppName, len(runner.calls))
+		}
+	}
+	if after, err := os.Getwd(); err != nil || after != wd {
+		t.Errorf("working directory changed from %s to %s (%v)", wd, after, err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// keyVaultCryptoRole is the built-in role the encryption identity needs on the key vault
+// holding a customer-managed key
+const keyVaultCryptoRole = "Key Vault Crypto Service Encryption User"
+
+// encryptionServiceNames lists the storage services accepted by ENCRYPTION_SERVICES
+var encryptionServiceNames = []string{"blob", "file", "queue", "table"}
+
+// keyVaultKey identifies a customer-managed key parsed from ENCRYPTION_KEY_VAULT_URI
+type keyVaultKey struct {
+	vaultURI string
+	name     string
+	version  string // empty to follow the latest key version
+}
+
+// parseKeySource maps an ENCRYPTION_KEY_SOURCE value such as Microsoft.Keyvault to its
+// armstorage.KeySource
+func parseKeySource(value string) (armstorage.KeySource, error) {
+	accepted := []string{}
+	for _, source := range armstorage.PossibleKeySourceValues() {
+		if strings.EqualFold(value, string(source)) {
+			return source, nil
+		}
+		accepted = append(accepted, string(source))
+	}
+	return "", fmt.Errorf("unknown key source %q, accepted values are: %s", value, strings.Join(accepted, ", "))
+}
+
+// parseKeyVaultKeyURI splits a Key Vault key URI of the form
+// https://<vault>.vault.azure.net/keys/<name>[/<version>] into its parts
+func parseKeyVaultKeyURI(value string) (keyVaultKey, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return keyVaultKey{}, fmt.Errorf("malformed key URI %q, expected https://<vault>/keys/<name>[/<version>]", value)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "keys" || parts[1] == "" {
+		return keyVaultKey{}, fmt.Errorf("malformed key URI %q, expected https://<vault>/keys/<name>[/<version>]", value)
+	}
+
+	key := keyVaultKey{vaultURI: "https://" + u.Host + "/", name: parts[1]}
+	if len(parts) == 3 {
+		key.version = parts[2]
+	}
+	return key, nil
+}
+
+// parseEncryptionServices parses the comma-separated ENCRYPTION_SERVICES list
+func parseEncryptionServices(value string) ([]string, error) {
+	services := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(encryptionServiceNames, name) {
+			return nil, fmt.Errorf("unknown storage service %q, accepted values are: %s", name, strings.Join(encryptionServiceNames, ", "))
+		}
+		if !slices.Contains(services, name) {
+			services = append(services, name)
+		}
+	}
+	return services, nil
+}
+
+// validateEncryption checks the storage encryption settings. Customer-managed keys need
+// both the key URI and a user-assigned identity allowed to use the key
+func validateEncryption(cfg Config) error {
+	keySource, err := parseKeySource(cfg.EncryptionKeySource)
+	if err != nil {
+		return fmt.Errorf("invalid ENCRYPTION_KEY_SOURCE: %w", err)
+	}
+	if _, err := parseEncryptionServices(cfg.EncryptionServices); err != nil {
+		return fmt.Errorf("invalid ENCRYPTION_SERVICES: %w", err)
+	}
+	if keySource != armstorage.KeySourceMicrosoftKeyvault {
+		return nil
+	}
+
+	if cfg.EncryptionKeyVaultURI == "" {
+		return fmt.Errorf("ENCRYPTION_KEY_VAULT_URI is required when ENCRYPTION_KEY_SOURCE is %s", keySource)
+	}
+	key, err := parseKeyVaultKeyURI(cfg.EncryptionKeyVaultURI)
+	if err != nil {
+		return fmt.Errorf("invalid ENCRYPTION_KEY_VAULT_URI: %w", err)
+	}
+	if cfg.EncryptionIdentityID == "" {
+		return fmt.Errorf("ENCRYPTION_IDENTITY_ID is required when ENCRYPTION_KEY_SOURCE is %s", keySource)
+	}
+	if !isUserAssignedIdentityID(cfg.EncryptionIdentityID) {
+		return fmt.Errorf("invalid ENCRYPTION_IDENTITY_ID %q, expected a Microsoft.ManagedIdentity/userAssignedIdentities resource ID",
+			cfg.EncryptionIdentityID)
+	}
+	log.Printf("Customer-managed key %s: ENCRYPTION_IDENTITY_ID needs the %q role on key vault %s",
+		key.name, keyVaultCryptoRole, key.vaultURI)
+	return nil
+}
+
+// withKeyVaultHint adds the access a customer-managed key requires to a storage account
+// creation error, since a missing role assignment is the usual cause
+func withKeyVaultHint(cfg Config, err error) error {
+	if !strings.EqualFold(cfg.EncryptionKeySource, string(armstorage.KeySourceMicrosoftKeyvault)) {
+		return err
+	}
+	key, _ := parseKeyVaultKeyURI(cfg.EncryptionKeyVaultURI)
+	return fmt.Errorf("%w\nIdentity %s needs the %q role (or get, wrapKey and unwrapKey key permissions) on key vault %s, "+
+		"and the vault must have soft delete and purge protection enabled", err, cfg.EncryptionIdentityID, keyVaultCryptoRole, key.vaultURI)
+}
+
+// buildEncryption builds the Storage Account encryption settings and, for
+// customer-managed keys, the identity used to access the key
+func buildEncryption(cfg Config) (*armstorage.Encryption, *armstorage.Identity, error) {
+	keySource, err := parseKeySource(cfg.EncryptionKeySource)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := parseEncryptionServices(cfg.EncryptionServices)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryption := &armstorage.Encryption{
+		KeySource: to.Ptr(keySource),
+		Services:  &armstorage.EncryptionServices{},
+	}
+	// Services left out keep Azure's default service-managed encryption
+	for _, name := range services {
+		service := &armstorage.EncryptionService{KeyType: to.Ptr(armstorage.KeyTypeAccount), Enabled: to.Ptr(true)}
+		switch name {
+		case "blob":
+			encryption.Services.Blob = service
+		case "file":
+			encryption.Services.File = service
+		case "queue":
+			encryption.Services.Queue = service
+		case "table":
+			encryption.Services.Table = service
+		}
+	}
+
+	if keySource != armstorage.KeySourceMicrosoftKeyvault {
+		return encryption, nil, nil
+	}
+
+	key, err := parseKeyVaultKeyURI(cfg.EncryptionKeyVaultURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryption.KeyVaultProperties = &armstorage.KeyVaultProperties{
+		KeyVaultURI: to.Ptr(key.vaultURI),
+		KeyName:     to.Ptr(key.name),
+	}
+	if key.version != "" {
+		encryption.KeyVaultProperties.KeyVersion = to.Ptr(key.version)
+	}
+	encryption.EncryptionIdentity = &armstorage.EncryptionIdentity{
+		EncryptionUserAssignedIdentity: to.Ptr(cfg.EncryptionIdentityID),
+	}
+	identity := &armstorage.Identity{
+		Type: to.Ptr(armstorage.IdentityTypeUserAssigned),
+		UserAssignedIdentities: map[string]*armstorage.UserAssignedIdentity{
+			cfg.EncryptionIdentityID: {},
+		},
+	}
+	return encryption, identity, nil
+}
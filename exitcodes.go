@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Process exit codes, one per failure category, so that CI can tell failures apart
+// without parsing the log
+const (
+	exitFailure     = 1   // uncategorized failure, such as writing OUTPUT_FILE
+	exitConfig      = 2   // invalid or missing configuration
+	exitDependency  = 3   // az or func CLI missing or too old
+	exitAuth        = 4   // no valid Azure credential, or access denied
+	exitAzureAPI    = 5   // an Azure API call or az command managing resources failed
+	exitPublish     = 6   // preparing, publishing or verifying the function code failed
+	exitInterrupted = 130 // cancelled by SIGINT or SIGTERM, as shells report for SIGINT
+)
+
+// stepExitCodes maps the steps whose failures are not Azure API errors to their exit
+// code. Failures of any other step exit with exitAzureAPI
+var stepExitCodes = map[string]int{
+	StepValidateConfig:   exitConfig,
+	StepCheckCommands:    exitDependency,
+	StepCredentials:      exitAuth,
+	StepVerifyCredential: exitAuth,
+	StepInitProject:      exitPublish,
+	StepCreateFunction:   exitPublish,
+	StepPublish:          exitPublish,
+	StepVerifyFunctions:  exitPublish,
+	StepWriteResult:      exitFailure,
+	StepExportConnection: exitFailure,
+	StepExportARM:        exitFailure,
+}
+
+// exitCode returns the exit status of a run that failed with err. An interrupted run
+// exits with exitInterrupted; otherwise the failed step gives the category, except that
+// Azure rejecting the credential or denying access is always an auth failure. When
+// several deployments failed, the first failure decides
+func exitCode(ctx context.Context, err error) int {
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && (respErr.StatusCode == http.StatusUnauthorized || respErr.StatusCode == http.StatusForbidden) {
+		return exitAuth
+	}
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) {
+		return exitFailure
+	}
+	if code, ok := stepExitCodes[stepErr.Step]; ok {
+		return code
+	}
+	return exitAzureAPI
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// funcProgrammingModels lists the FUNC_PROGRAMMING_MODEL values `func init --model`
+// accepts for each runtime that has more than one programming model
+var funcProgrammingModels = map[string][]string{
+	"node":   {"V3", "V4"},
+	"python": {"V1", "V2"},
+}
+
+// funcLanguages lists the FUNC_LANGUAGE values `func init --language` accepts for each
+// runtime that supports more than one language
+var funcLanguages = map[string][]string{
+	"node": {"javascript", "typescript"},
+}
+
+// validateFuncInit checks FUNC_PROGRAMMING_MODEL and FUNC_LANGUAGE against the
+// FUNCTION_RUNTIME they are scaffolded for
+func validateFuncInit(cfg Config) error {
+	if cfg.FuncProgrammingModel != "" {
+		models, ok := funcProgrammingModels[cfg.FunctionRuntime]
+		if !ok {
+			return fmt.Errorf("FUNC_PROGRAMMING_MODEL is not supported for FUNCTION_RUNTIME %s", cfg.FunctionRuntime)
+		}
+		if !slices.Contains(models, cfg.FuncProgrammingModel) {
+			return fmt.Errorf("invalid FUNC_PROGRAMMING_MODEL %q for FUNCTION_RUNTIME %s, accepted values are: %s",
+				cfg.FuncProgrammingModel, cfg.FunctionRuntime, strings.Join(models, ", "))
+		}
+	}
+	if cfg.FuncLanguage != "" {
+		languages, ok := funcLanguages[cfg.FunctionRuntime]
+		if !ok {
+			return fmt.Errorf("FUNC_LANGUAGE is not supported for FUNCTION_RUNTIME %s", cfg.FunctionRuntime)
+		}
+		if !slices.Contains(languages, cfg.FuncLanguage) {
+			return fmt.Errorf("invalid FUNC_LANGUAGE %q for FUNCTION_RUNTIME %s, accepted values are: %s",
+				cfg.FuncLanguage, cfg.FunctionRuntime, strings.Join(languages, ", "))
+		}
+	}
+	return nil
+}
+
+// funcInitArgs returns the `func init` arguments scaffolding a project for
+// FUNCTION_RUNTIME with the configured programming model and language
+func funcInitArgs(cfg Config) []string {
+	args := []string{"init", "--worker-runtime", cfg.FunctionRuntime}
+	if cfg.FuncProgrammingModel != "" {
+		args = append(args, "--model", cfg.FuncProgrammingModel)
+	}
+	if cfg.FuncLanguage != "" {
+		args = append(args, "--language", cfg.FuncLanguage)
+	}
+	return args
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// supportedAuthLevels lists the HTTP trigger authorization levels accepted by `func new`
+var supportedAuthLevels = []string{"anonymous", "function", "admin"}
+
+// Per-function outcomes reported in the deployment result
+const (
+	functionStatusCreated = "created"
+	functionStatusExists  = "exists"
+	functionStatusFailed  = "failed"
+	functionStatusPlanned = "planned"
+)
+
+// functionSpec describes one function created with `func new`. It is also the format
+// of the entries of the FUNCTIONS manifest
+type functionSpec struct {
+	Name      string `json:"name"`
+	Template  string `json:"template"`
+	AuthLevel string `json:"authLevel"`
+}
+
+// FunctionResult reports what happened to one function of the deployment
+type FunctionResult struct {
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	Verification string `json:"verification,omitempty"`
+	InvokeURL    string `json:"invokeUrl,omitempty"`
+}
+
+// parseFunctions returns the functions to create. When the FUNCTIONS manifest is set it
+// is used, otherwise the comma-separated FUNCTION_NAME and FUNCTION_TEMPLATE lists are
+// paired. AUTH_LEVEL is either a single level applied to every function or a list of
+// the same length
+func parseFunctions(cfg Config) ([]functionSpec, error) {
+	if cfg.Functions != "" {
+		return parseFunctionManifest(cfg)
+	}
+
+	names := splitList(cfg.FunctionName)
+	templates := splitList(cfg.FunctionTemplate)
+	authLevels := splitList(cfg.AuthLevel)
+
+	if len(names) != len(templates) {
+		return nil, fmt.Errorf("FUNCTION_NAME lists %d functions but FUNCTION_TEMPLATE lists %d templates, the lists must be the same length",
+			len(names), len(templates))
+	}
+	if len(authLevels) != 1 && len(authLevels) != len(names) {
+		return nil, fmt.Errorf("AUTH_LEVEL lists %d levels, set a single level or one per function (%d)",
+			len(authLevels), len(names))
+	}
+
+	specs := []functionSpec{}
+	for i, name := range names {
+		authLevel := authLevels[0]
+		if len(authLevels) > 1 {
+			authLevel = authLevels[i]
+		}
+		specs = append(specs, functionSpec{Name: name, Template: templates[i], AuthLevel: authLevel})
+	}
+	return checkFunctionSpecs(specs, "FUNCTION_NAME")
+}
+
+// parseFunctionManifest parses FUNCTIONS, a JSON array of {name, template, authLevel}
+// objects. In a config file it is written as a list of maps. Entries without an auth
+// level use AUTH_LEVEL
+func parseFunctionManifest(cfg Config) ([]functionSpec, error) {
+	if cfg.FunctionName != "" {
+		log.Println("Warning: FUNCTIONS is set, ignoring FUNCTION_NAME, FUNCTION_TEMPLATE and the AUTH_LEVEL list")
+	}
+
+	specs := []functionSpec{}
+	if err := json.Unmarshal([]byte(cfg.Functions), &specs); err != nil {
+		return nil, fmt.Errorf("invalid FUNCTIONS, expected a list of objects with name, template and authLevel: %v", err)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("FUNCTIONS must list at least one function")
+	}
+	for i := range specs {
+		specs[i].Name = strings.TrimSpace(specs[i].Name)
+		specs[i].Template = strings.TrimSpace(specs[i].Template)
+		if specs[i].Name == "" || specs[i].Template == "" {
+			return nil, fmt.Errorf("FUNCTIONS entry %d must set both name and template", i+1)
+		}
+		if specs[i].AuthLevel == "" {
+			specs[i].AuthLevel = cfg.AuthLevel
+		}
+		if specs[i].AuthLevel == "" {
+			return nil, fmt.Errorf("function %s: set authLevel in FUNCTIONS or a default AUTH_LEVEL", specs[i].Name)
+		}
+	}
+	return checkFunctionSpecs(specs, "FUNCTIONS")
+}
+
+// checkFunctionSpecs rejects duplicate function names and normalizes the auth levels
+func checkFunctionSpecs(specs []functionSpec, setting string) ([]functionSpec, error) {
+	for i, spec := range specs {
+		if slices.ContainsFunc(specs[:i], func(s functionSpec) bool { return strings.EqualFold(s.Name, spec.Name) }) {
+			return nil, fmt.Errorf("duplicate function name %q in %s", spec.Name, setting)
+		}
+		authLevel, err := parseAuthLevel(spec.AuthLevel)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", spec.Name, err)
+		}
+		specs[i].AuthLevel = authLevel
+	}
+	return specs, nil
+}
+
+// parseAuthLevel checks an AUTH_LEVEL value against the supported levels, ignoring case,
+// and returns it lowercased as `func new` expects
+func parseAuthLevel(value string) (string, error) {
+	level := strings.ToLower(value)
+	if !slices.Contains(supportedAuthLevels, level) {
+		return "", fmt.Errorf("invalid AUTH_LEVEL %q, accepted values are: %s", value, strings.Join(supportedAuthLevels, ", "))
+	}
+	return level, nil
+}
+
+// createFunctions creates each configured function using `func new`. A function that
+// already exists in the project, found by functionExists or reported so by `func new`,
+// is skipped, and a failing function does not stop the others; the outcome of each is
+// returned and an error lists the ones that failed
+func createFunctions(ctx context.Context, cfg Config) ([]FunctionResult, error) {
+	functions, err := parseFunctions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.DryRun {
+		log.Println("Function App Project Directory:", cfg.FunctionProjectDir)
+	}
+	results := []FunctionResult{}
+	failed := []string{}
+	for _, fn := range functions {
+		if cfg.DryRun {
+			planDryRun("create function %s (func new --template %q --authlevel %s)",
+				fn.Name, fn.Template, fn.AuthLevel)
+			results = append(results, FunctionResult{Name: fn.Name, Status: functionStatusPlanned})
+			continue
+		}
+
+		if functionExists(cfg.FunctionProjectDir, fn.Name) {
+			log.Println("Function already exists, skipping:", fn.Name)
+			results = append(results, FunctionResult{Name: fn.Name, Status: functionStatusExists})
+			continue
+		}
+
+		// Define the arguments for `func new`
+		cmdArgs := []string{
+			"new",
+			"--name", fn.Name,
+			"--template", fn.Template,
+			"--authlevel", fn.AuthLevel,
+		}
+
+		// Run inside the project directory without changing the process working directory
+		output, err := runCommand(ctx, cfg, cfg.FunctionProjectDir, "func", cmdArgs...)
+		if err != nil && strings.Contains(strings.ToLower(string(output)), "already exists") {
+			log.Println("Function already exists, skipping:", fn.Name)
+			results = append(results, FunctionResult{Name: fn.Name, Status: functionStatusExists})
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("func new failed for function %s: %v\nOutput: %s", fn.Name, err, outputTail(output))
+			log.Println(err)
+			results = append(results, FunctionResult{Name: fn.Name, Status: functionStatusFailed, Error: err.Error()})
+			failed = append(failed, fn.Name)
+			continue
+		}
+
+		logCommandOutput(cfg, "func new", output)
+		log.Println("Function created:", fn.Name)
+		results = append(results, FunctionResult{Name: fn.Name, Status: functionStatusCreated})
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("failed to create %d of %d functions: %s", len(failed), len(functions), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// functionScriptExtensions are the source files of the Node.js v4 programming model,
+// which keeps one file per function under src/functions
+var functionScriptExtensions = []string{".js", ".ts", ".mjs", ".cjs"}
+
+// functionExists reports whether the project already defines the function: as a
+// function folder in the classic programming models, a src/functions file in the
+// Node.js v4 model, or a decorated function in function_app.py in the Python v2 model
+func functionExists(projectDir, name string) bool {
+	if info, err := os.Stat(filepath.Join(projectDir, name)); err == nil && info.IsDir() {
+		return true
+	}
+	for _, ext := range functionScriptExtensions {
+		if _, err := os.Stat(filepath.Join(projectDir, "src", "functions", name+ext)); err == nil {
+			return true
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(projectDir, "function_app.py"))
+	if err != nil {
+		return false
+	}
+	return pythonFunctionPattern(name).Match(data)
+}
+
+// pythonFunctionPattern matches the definition of a Python v2 function, named either by
+// its def or by @app.function_name
+func pythonFunctionPattern(name string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+	return regexp.MustCompile(`(?m)^\s*(?:async\s+)?def\s+` + quoted + `\s*\(|function_name\(\s*(?:name\s*=\s*)?["']` + quoted + `["']`)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCreateFunctionsSkipsExisting(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"src/functions/NodeFunction.js": "app.http('NodeFunction', {})\n",
+		"function_app.py":               "@app.route(route=\"hello\")\ndef PythonFunction(req):\n    pass\n",
+		"ClassicFunction/function.json": "{}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+		if slices.Contains(call.args, "Reported") {
+			return []byte("A function with the name Reported already exists."), errors.New("exit status 1")
+		}
+		return nil, nil
+	}}
+	cfg := testConfig()
+	cfg.FunctionProjectDir = dir
+	cfg.CommandRunner = runner
+	cfg.FunctionName = "NodeFunction,PythonFunction,ClassicFunction,Reported,NewFunction"
+	cfg.FunctionTemplate = "HTTP trigger,HTTP trigger,HTTP trigger,HTTP trigger,HTTP trigger"
+	cfg.AuthLevel = "function"
+
+	results, err := createFunctions(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("createFunctions: %v", err)
+	}
+	want := map[string]string{
+		"NodeFunction":    functionStatusExists,
+		"PythonFunction":  functionStatusExists,
+		"ClassicFunction": functionStatusExists,
+		"Reported":        functionStatusExists,
+		"NewFunction":     functionStatusCreated,
+	}
+	for _, result := range results {
+		if result.Status != want[result.Name] {
+			t.Errorf("function %s: status %s, want %s", result.Name, result.Status, want[result.Name])
+		}
+	}
+	if len(runner.calls) != 2 {
+		t.Errorf("func new ran %d times, want 2 for the functions not found in the project", len(runner.calls))
+	}
+}
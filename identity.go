@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// managedIdentity holds the fields read from the JSON printed by
+// `az functionapp identity assign`
+type managedIdentity struct {
+	PrincipalID string `json:"principalId"`
+	Type        string `json:"type"`
+}
+
+// validateUserAssignedIdentity checks that USER_ASSIGNED_IDENTITY_ID, when set, is the
+// resource ID of a user-assigned managed identity
+func validateUserAssignedIdentity(cfg Config) error {
+	if cfg.UserAssignedIdentityID == "" {
+		return nil
+	}
+	if !isUserAssignedIdentityID(cfg.UserAssignedIdentityID) {
+		return fmt.Errorf("invalid USER_ASSIGNED_IDENTITY_ID %q, expected a Microsoft.ManagedIdentity/userAssignedIdentities resource ID",
+			cfg.UserAssignedIdentityID)
+	}
+	return nil
+}
+
+// isUserAssignedIdentityID reports whether value is the resource ID of a user-assigned
+// managed identity
+func isUserAssignedIdentityID(value string) bool {
+	id, err := arm.ParseResourceID(value)
+	return err == nil && strings.EqualFold(id.ResourceType.String(), "Microsoft.ManagedIdentity/userAssignedIdentities")
+}
+
+// identitiesToAssign returns the identities passed to `az functionapp create
+// --assign-identity` and `az functionapp identity assign --identities`: [system] when
+// ASSIGN_IDENTITY is set and the user-assigned identity when USER_ASSIGNED_IDENTITY_ID is set
+func identitiesToAssign(cfg Config) []string {
+	identities := []string{}
+	if cfg.AssignIdentity {
+		identities = append(identities, "[system]")
+	}
+	if cfg.UserAssignedIdentityID != "" {
+		identities = append(identities, cfg.UserAssignedIdentityID)
+	}
+	return identities
+}
+
+// assignManagedIdentity enables the configured managed identities on an existing
+// Function App using `az functionapp identity assign` and returns the system-assigned
+// principal ID, which is empty when only a user-assigned identity is configured
+func assignManagedIdentity(ctx context.Context, cfg Config) (string, error) {
+	cmdArgs := []string{
+		"functionapp", "identity", "assign",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.AzureFunctionAppName,
+		"--identities",
+	}
+	cmdArgs = append(cmdArgs, identitiesToAssign(cfg)...)
+	cmdArgs = append(cmdArgs, "--output", "json")
+
+	if cfg.DryRun {
+		planDryRun("assign managed identity to Function App %s (az %s)", cfg.AzureFunctionAppName, strings.Join(cmdArgs, " "))
+		return "", nil
+	}
+
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
+	if err != nil {
+		return "", fmt.Errorf("az functionapp identity assign failed: %v\nOutput: %s", err, outputTail(output))
+	}
+
+	var identity managedIdentity
+	if err := json.Unmarshal(output, &identity); err != nil {
+		return "", fmt.Errorf("failed to parse az functionapp identity assign output: %v", err)
+	}
+	log.Printf("Managed identity assigned to Function App %s (type %s)", cfg.AzureFunctionAppName, identity.Type)
+	if identity.PrincipalID != "" {
+		log.Println("System-assigned identity principal ID:", identity.PrincipalID)
+	}
+	return identity.PrincipalID, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// keyVaultRefPrefix marks a setting whose value is read from a Key Vault secret, as in
+// @kv:https://myvault.vault.azure.net/secrets/name
+const keyVaultRefPrefix = "@kv:"
+
+// keyVaultResolveTimeout bounds resolving every Key Vault reference at startup
+const keyVaultResolveTimeout = 2 * time.Minute
+
+// credentialKeys are the settings newCredential reads. References among them are
+// resolved with the default credential, since they cannot be used to read themselves
+var credentialKeys = []string{"AUTH_METHOD", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID"}
+
+// resolveKeyVaultReferences replaces every setting of configKeys whose value is a Key
+// Vault reference with the secret it points to, before the configuration is loaded.
+// References to the credential settings are resolved first, with the default
+// credential; the others with the configured credential. Secret values are never logged
+func resolveKeyVaultReferences() error {
+	refs := map[string]string{}
+	for _, key := range configKeys {
+		if value, ok := strings.CutPrefix(os.Getenv(key), keyVaultRefPrefix); ok {
+			refs[key] = value
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyVaultResolveTimeout)
+	defer cancel()
+
+	cfg := keyVaultCredentialConfig()
+	var authRefs, otherRefs []string
+	for _, key := range slices.Sorted(maps.Keys(refs)) {
+		if slices.Contains(credentialKeys, key) {
+			authRefs = append(authRefs, key)
+		} else {
+			otherRefs = append(otherRefs, key)
+		}
+	}
+
+	if len(authRefs) > 0 {
+		cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions(cfg)})
+		if err != nil {
+			return fmt.Errorf("failed to create credential for Key Vault references: %v", err)
+		}
+		if err := resolveKeyVaultKeys(ctx, cfg, cred, authRefs, refs); err != nil {
+			return err
+		}
+		cfg = keyVaultCredentialConfig()
+	}
+	if len(otherRefs) > 0 {
+		if err := validateAuth(cfg); err != nil {
+			return err
+		}
+		cred, err := newCredential(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create credential for Key Vault references: %v", err)
+		}
+		if err := resolveKeyVaultKeys(ctx, cfg, cred, otherRefs, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyVaultCredentialConfig returns the settings newCredential needs, read from the
+// environment with the same defaults as loadConfig
+func keyVaultCredentialConfig() Config {
+	return Config{
+		AzureCloud:   getEnvOrDefault("AZURE_CLOUD", defaultAzureCloud),
+		AuthMethod:   strings.ToLower(getEnvOrDefault("AUTH_METHOD", authMethodDefault)),
+		ClientID:     os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret: os.Getenv("AZURE_CLIENT_SECRET"),
+		TenantID:     os.Getenv("AZURE_TENANT_ID"),
+	}
+}
+
+// resolveKeyVaultKeys reads the secret referenced by each of keys and sets it in the
+// environment in place of the reference
+func resolveKeyVaultKeys(ctx context.Context, cfg Config, cred azcore.TokenCredential, keys []string, refs map[string]string) error {
+	for _, key := range keys {
+		value, err := readKeyVaultSecret(ctx, cfg, cred, refs[key])
+		if err != nil {
+			return fmt.Errorf("failed to resolve Key Vault reference of %s: %w", key, err)
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+		log.Println("Resolved Key Vault reference of", key)
+	}
+	return nil
+}
+
+// readKeyVaultSecret reads a secret by its URL, https://<vault>.<suffix>/secrets/<name>
+// with an optional /<version>, using the azsecrets client. The client takes the token
+// scope from the vault's authentication challenge, so references work in every Azure cloud
+func readKeyVaultSecret(ctx context.Context, cfg Config, cred azcore.TokenCredential, secretURL string) (string, error) {
+	u, err := url.Parse(secretURL)
+	if err != nil || u.Scheme != "https" || !strings.Contains(u.Host, ".vault.") {
+		return "", fmt.Errorf("invalid Key Vault secret URL %q, expected https://<vault>.vault.azure.net/secrets/<name>", secretURL)
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || len(segments) > 3 || segments[0] != "secrets" || segments[1] == "" {
+		return "", fmt.Errorf("invalid Key Vault secret URL %q, expected https://<vault>.vault.azure.net/secrets/<name>", secretURL)
+	}
+	version := ""
+	if len(segments) == 3 {
+		version = segments[2]
+	}
+
+	client, err := azsecrets.NewClient("https://"+u.Host, cred, &azsecrets.ClientOptions{ClientOptions: clientOptions(cfg)})
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.GetSecret(ctx, segments[1], version, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("secret %s has no value", secretURL)
+	}
+	return *resp.Value, nil
+}
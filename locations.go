@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+)
+
+// azureLocation is a region of the subscription
+type azureLocation struct {
+	Name        string
+	DisplayName string
+}
+
+// normalizeLocation converts a location display name such as "East US" to its
+// programmatic name "eastus", trimming surrounding whitespace
+func normalizeLocation(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), ""))
+}
+
+// listLocations returns the physical locations of the subscription, sorted by name.
+// Listing them fails with SubscriptionNotFound when the subscription does not exist
+func listLocations(ctx context.Context, cfg Config) ([]azureLocation, error) {
+	locations := []azureLocation{}
+	pager := subscriptionsClient.NewListLocationsPager(cfg.AzureSubscriptionID, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) && slices.Contains(missingSubscriptionCodes, respErr.ErrorCode) {
+				return nil, fmt.Errorf("subscription %s was not found or is not accessible with the current credentials: %w", cfg.AzureSubscriptionID, err)
+			}
+			return nil, fmt.Errorf("failed to list locations: %w", err)
+		}
+		for _, l := range page.Value {
+			if l.Metadata != nil && l.Metadata.RegionType != nil && *l.Metadata.RegionType != armsubscriptions.RegionTypePhysical {
+				continue
+			}
+			locations = append(locations, azureLocation{
+				Name:        derefString(l.Name),
+				DisplayName: derefString(l.DisplayName),
+			})
+		}
+	}
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("subscription %s lists no locations", cfg.AzureSubscriptionID)
+	}
+	slices.SortFunc(locations, func(a, b azureLocation) int { return strings.Compare(a.Name, b.Name) })
+	return locations, nil
+}
+
+// validateLocation checks AZURE_LOCATION against the locations available to the
+// subscription. The call is read-only, so it also runs in dry-run mode
+func validateLocation(ctx context.Context, cfg Config) error {
+	locations, err := listLocations(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(locations, func(l azureLocation) bool { return l.Name == cfg.AzureLocation }) {
+		log.Println("Location validated:", cfg.AzureLocation)
+		return nil
+	}
+	names := []string{}
+	for _, l := range locations {
+		names = append(names, l.Name)
+	}
+	return fmt.Errorf("AZURE_LOCATION %q is not available to subscription %s, valid locations are: %s (run with --list-locations to see their display names)",
+		cfg.AzureLocation, cfg.AzureSubscriptionID, strings.Join(names, ", "))
+}
+
+// ListLocations prints the location names accepted by AZURE_LOCATION to stdout, with
+// their display names. Only the subscription and credentials need to be configured
+func ListLocations(ctx context.Context, config Config) error {
+	if config.AzureSubscriptionID == "" {
+		return &StepError{Step: StepValidateConfig, Err: errors.New("missing required environment variable AZURE_SUBSCRIPTION_ID")}
+	}
+	if _, err := lookupAzureCloud(config.AzureCloud); err != nil {
+		return &StepError{Step: StepValidateConfig, Err: fmt.Errorf("invalid AZURE_CLOUD: %w", err)}
+	}
+	if err := validateAuth(config); err != nil {
+		return &StepError{Step: StepValidateConfig, Err: err}
+	}
+	cred, err := newCredential(config)
+	if err != nil {
+		return &StepError{Step: StepCredentials, Err: err}
+	}
+	if err := initClients(config, cred); err != nil {
+		return &StepError{Step: StepInitClients, Err: err}
+	}
+
+	locations, err := listLocations(ctx, config)
+	if err != nil {
+		return &StepError{Step: StepListLocations, Err: err}
+	}
+	for _, l := range locations {
+		fmt.Printf("%-24s %s\n", l.Name, l.DisplayName)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestValidateLocation(t *testing.T) {
+	missing := &azcore.ResponseError{
+		StatusCode:  http.StatusNotFound,
+		ErrorCode:   "SubscriptionNotFound",
+		RawResponse: &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}},
+	}
+	tests := []struct {
+		name          string
+		subscriptions *fakeSubscriptions
+		wantErr       string
+	}{
+		{name: "available", subscriptions: &fakeSubscriptions{locations: []string{"northeurope", "westeurope"}}},
+		{name: "unavailable", subscriptions: &fakeSubscriptions{locations: []string{"northeurope", "eastus"}}, wantErr: "valid locations are: eastus, northeurope"},
+		{name: "unknown subscription", subscriptions: &fakeSubscriptions{err: missing}, wantErr: "subscription sub was not found"},
+		{name: "no locations", subscriptions: &fakeSubscriptions{}, wantErr: "lists no locations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeClients(t, &fakeResourceGroups{}, &fakeStorageAccounts{}, &fakeResources{})
+			subscriptionsClient = tt.subscriptions
+
+			err := validateLocation(context.Background(), testConfig())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateLocation: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateLocation error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListLocationsRejectsUnknownCloud(t *testing.T) {
+	cfg := testConfig()
+	cfg.AzureCloud = "moon"
+	err := ListLocations(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid AZURE_CLOUD") {
+		t.Fatalf("ListLocations error = %v, want an invalid AZURE_CLOUD error", err)
+	}
+}
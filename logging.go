@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Log formats accepted by LOG_FORMAT
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging configures the default logger for LOG_FORMAT. The text format keeps the
+// standard log output, while the json format routes both log and slog output through a
+// JSON handler so every line is a single JSON object
+func setupLogging(format string, dryRun bool) error {
+	switch format {
+	case logFormatText:
+		return nil
+	case logFormatJSON:
+		logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		if dryRun {
+			logger = logger.With("dry_run", true)
+		}
+		// slog.SetDefault also redirects the log package, so existing log.Println
+		// calls are emitted as JSON too
+		slog.SetDefault(logger)
+		log.SetPrefix("")
+		return nil
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q, accepted values are: %s, %s", format, logFormatText, logFormatJSON)
+	}
+}
+
+// stepTracker logs a start and end event for each deployment step, including the
+// outcome and how long the step took, and bounds each step with a timeout
+type stepTracker struct {
+	parent  context.Context
+	timeout time.Duration
+
+	step     string
+	resource string
+	start    time.Time
+	active   bool
+	cancel   context.CancelFunc
+}
+
+// begin finishes the current step successfully, if any, and starts tracking the next
+// one. The returned context is derived from the parent and expires after the timeout
+func (t *stepTracker) begin(step, resource string) context.Context {
+	t.finish(nil)
+
+	ctx, cancel := context.WithTimeout(t.parent, t.timeout)
+	t.step, t.resource, t.start, t.active, t.cancel = step, resource, time.Now(), true, cancel
+	slog.Info("step started", "step", step, "resource", resource, "status", "started")
+	return ctx
+}
+
+// finish logs the end of the current step, marking it failed when err is not nil
+func (t *stepTracker) finish(err error) {
+	if !t.active {
+		return
+	}
+	t.active = false
+	t.cancel()
+
+	attrs := []any{
+		"step", t.step,
+		"resource", t.resource,
+		"duration_ms", time.Since(t.start).Milliseconds(),
+	}
+	if err != nil {
+		slog.Error("step finished", append(attrs, "status", "failed", "error", err.Error())...)
+		return
+	}
+	slog.Info("step finished", append(attrs, "status", "succeeded")...)
+}
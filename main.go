@@ -1,292 +1,2009 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
 	"github.com/joho/godotenv"
+	"golang.org/x/sync/errgroup"
 )
 
 // Config holds all the configuration variables loaded from the .env file
 type Config struct {
-	AzureSubscriptionID     string
-	AzureLocation           string
-	AzureResourceGroupName  string
-	AzureStorageAccountName string
-	AzureFunctionAppName    string
-	FunctionName            string
-	FunctionTemplate        string
-	AuthLevel               string
-	KeepResource            string
+	AzureSubscriptionID       string
+	AzureLocation             string
+	AzureResourceGroupName    string
+	AzureStorageAccountName   string
+	AzureFunctionAppName      string
+	FunctionName              string
+	Functions                 string
+	Deployments               string
+	DeployConcurrency         int
+	FunctionTemplate          string
+	AuthLevel                 string
+	SkipTemplateValidation    bool
+	KeepResource              bool
+	FunctionProjectDir        string
+	DryRun                    bool
+	StorageSKU                string
+	StorageAccessTier         string
+	StorageKind               string
+	StoragePremium            bool
+	StorageMinTLS             string
+	StorageHTTPSOnly          bool
+	BlobSoftDeleteDays        int
+	EncryptionKeySource       string
+	EncryptionKeyVaultURI     string
+	EncryptionIdentityID      string
+	EncryptionServices        string
+	NetworkDefaultAction      string
+	AllowedIPRanges           string
+	AllowedSubnetIDs          string
+	BlobContainers            string
+	BlobContainerPublicAccess string
+	BlobVersioning            bool
+	EnableHNS                 bool
+	EnableSFTP                bool
+	EnableLargeFileShares     bool
+	FunctionRuntime           string
+	AzureOSType               string
+	FuncProgrammingModel      string
+	FuncLanguage              string
+	ContainerImage            string
+	RegistryServer            string
+	RegistryUsername          string
+	RegistryPassword          string
+	FunctionRuntimeVersion    string
+	FunctionsVersion          string
+	PublishMode               string
+	ResourceTags              string
+	ReuseResourceGroup        string
+	UseExistingStorageAccount bool
+	StorageResourceGroup      string
+	RollbackOnFailure         bool
+	AzPath                    string
+	MinAzVersion              string
+	MinFuncVersion            string
+	FuncPath                  string
+	CLITimeout                time.Duration
+	PlanType                  string
+	PlanSKU                   string
+	PlanName                  string
+	Mode                      string
+	AutoApprove               bool
+	AppSettings               string
+	AppSettingsFile           string
+	EnableAppInsights         bool
+	AssignIdentity            bool
+	UserAssignedIdentityID    string
+	AppInsightsName           string
+	LogFormat                 string
+	Verbose                   bool
+	StepTimeout               time.Duration
+	DeployTimeout             time.Duration
+	VerifyDeployment          bool
+	SkipPublish               bool
+	SkipInfra                 bool
+	UpdateRuntimeVersion      bool
+	IfAppExists               string
+	Parallel                  bool
+	CheckQuota                bool
+	ExportARM                 string
+	AllowedOrigins            string
+	VerifyTimeout             time.Duration
+	MaxRetries                int
+	RetryBaseDelay            time.Duration
+	OutputFile                string
+	ShowSecrets               bool
+	ExportConnectionString    bool
+	ConnectionStringOutput    string
+	AzureCloud                string
+	AuthMethod                string
+	ClientID                  string
+	ClientSecret              string
+	TenantID                  string
+	// CommandOutput receives the raw live az/func output when Verbose is set instead of
+	// the logger; it is only configurable when calling Deploy directly
+	CommandOutput io.Writer
+	// CommandRunner runs the az and func CLIs; it defaults to running them as local
+	// processes and is only configurable when calling Deploy directly
+	CommandRunner CommandRunner
+	// ProgressFunc is called every ProgressInterval while waiting for a long-running
+	// Azure operation; it defaults to logging a heartbeat and is only configurable when
+	// calling Deploy directly
+	ProgressFunc     ProgressFunc
+	ProgressInterval time.Duration
+	// PollFrequency is the time between two polls of a long-running Azure operation;
+	// when zero it is polled every ProgressInterval
+	PollFrequency time.Duration
 }
 
 // Global variables for Azure SDK clients
 var (
 	resourcesClientFactory *armresources.ClientFactory
 	storageClientFactory   *armstorage.ClientFactory
-	resourceGroupClient    *armresources.ResourceGroupsClient
-	accountsClient         *armstorage.AccountsClient
+	resourcesClient        ResourceAPI
+	resourceGroupClient    ResourceGroupAPI
+	accountsClient         StorageAccountAPI
+	blobServicesClient     BlobServiceAPI
+	blobContainersClient   BlobContainerAPI
+	usagesClient           UsagesAPI
+	subscriptionsClient    SubscriptionAPI
 )
 
-// functionProjectDir defines the directory for your Function App project
-const functionProjectDir = `C:\Project\jx\functionapp` // Ensure this path exists
+// dryRunPlan records every action that was skipped because of dry-run mode. It is
+// guarded by dryRunPlanMu as deployments may run concurrently
+var (
+	dryRunPlan   []string
+	dryRunPlanMu sync.Mutex
+)
+
+// Deployment step names reported by StepError
+const (
+	StepValidateConfig       = "validate config"
+	StepCheckCommands        = "check commands"
+	StepCredentials          = "obtain credential"
+	StepInitClients          = "initialize clients"
+	StepVerifyCredential     = "verify credential"
+	StepValidateLocation     = "validate location"
+	StepCheckQuota           = "check storage quota"
+	StepCreateResourceGroup  = "create resource group"
+	StepCheckStorageName     = "check storage account name"
+	StepCreateStorageAccount = "create storage account"
+	StepBlobDataProtection   = "configure blob data protection"
+	StepStorageProperties    = "get storage account properties"
+	StepBlobContainers       = "create blob containers"
+	StepExportConnection     = "export connection string"
+	StepInitProject          = "initialize function project"
+	StepCreateFunction       = "create function"
+	StepCreateAppInsights    = "create application insights"
+	StepCreateHostingPlan    = "create hosting plan"
+	StepCreateFunctionApp    = "create function app"
+	StepManagedIdentity      = "assign managed identity"
+	StepCORS                 = "configure CORS"
+	StepAppSettings          = "configure app settings"
+	StepCheckFunctionApp     = "check function app"
+	StepPublish              = "publish function app"
+	StepVerifyFunctions      = "verify functions"
+	StepCleanup              = "clean up resources"
+	StepWriteResult          = "write deployment result"
+	StepExportARM            = "export ARM template"
+	StepListLocations        = "list locations"
+	StepPlan                 = "plan changes"
+)
+
+// StepError wraps the error returned by a failed deployment step with the step's name
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %q: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// defaultFunctionProjectDir is the Function App project directory, relative to the
+// current working directory, used when FUNCTION_PROJECT_DIR is not set
+const defaultFunctionProjectDir = "functionapp"
+
+// Hosting plan types accepted by PLAN_TYPE
+const (
+	planTypeConsumption = "consumption"
+	planTypePremium     = "premium"
+	planTypeDedicated   = "dedicated"
+)
+
+// What IF_APP_EXISTS does when the Function App already exists. Without it the run
+// fails, so an existing app is only changed when asked for explicitly
+const (
+	appExistsFail     = "fail"
+	appExistsUpdate   = "update"
+	appExistsRecreate = "recreate"
+)
+
+// Function App operating systems accepted by OS_TYPE
+const (
+	osTypeLinux   = "linux"
+	osTypeWindows = "windows"
+)
+
+// linuxOnlyRuntimes lists the FUNCTION_RUNTIME values Azure Functions only hosts on Linux
+var linuxOnlyRuntimes = []string{"python"}
+
+// defaultCLITimeout bounds each az/func invocation when CLI_TIMEOUT is not set
+const defaultCLITimeout = 10 * time.Minute
+
+// defaultStepTimeout bounds each deployment step when STEP_TIMEOUT is not set
+const defaultStepTimeout = 30 * time.Minute
+
+// cliGracePeriod is how long a cancelled az/func command may take to exit after being
+// interrupted before it is killed
+const cliGracePeriod = 10 * time.Second
+
+// Retry defaults for transient Azure failures when MAX_RETRIES and RETRY_BASE_DELAY are not set
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 2 * time.Second
+)
+
+// supportedFunctionRuntimes lists the accepted FUNCTION_RUNTIME values
+var supportedFunctionRuntimes = []string{"node", "python", "dotnet", "java", "powershell"}
+
+// defaultRuntimeVersions is the FUNCTION_RUNTIME_VERSION used for each runtime when it is not set
+var defaultRuntimeVersions = map[string]string{
+	"node":       "18",
+	"python":     "3.11",
+	"dotnet":     "8",
+	"java":       "17",
+	"powershell": "7.4",
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "log the intended actions without calling Azure or the az/func CLIs")
+	listLocationsOnly := flag.Bool("list-locations", false, "print the locations available to the subscription, same as the list-locations subcommand")
+	cleanupOnly := flag.Bool("cleanup-only", false, "only delete the configured resource group, same as the cleanup subcommand")
+	confirmDelete := flag.Bool("yes", false, "delete the resource group without asking for confirmation, same as AUTO_APPROVE=true")
+	skipPublish := flag.Bool("skip-publish", false, "provision the infrastructure only, same as SKIP_PUBLISH=true")
+	skipInfra := flag.Bool("skip-infra", false, "only publish to an existing Function App, same as SKIP_INFRA=true")
+	ifAppExists := flag.String("if-app-exists", "", "fail (default), update or recreate when the Function App already exists, same as IF_APP_EXISTS")
+	force := flag.Bool("force", false, "delete and recreate an existing Function App, same as --if-app-exists=recreate")
+	planOnly := flag.Bool("plan-only", false, "print how the Azure resources differ from the configuration without changing anything, same as the plan subcommand")
+	exportARM := flag.String("export-arm", "", "write an ARM template of the deployment to this file, or - for stdout, instead of deploying")
+	outputFile := flag.String("output", "", "write the deployment result as JSON to this file, or - for stdout, same as OUTPUT_FILE")
+	configPath := flag.String("config", "", "path to a YAML or JSON config file, defaults to CONFIG_FILE; environment variables and .env override its values")
+	flag.Parse()
+
+	// Load environment variables from .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Error loading .env file:", err)
+	} else {
+		log.Println(".env file loaded successfully.")
+	}
+
+	// Load the optional config file, without overriding variables already set. CONFIG_FILE
+	// may come from the environment or .env, but --config takes precedence
+	if *configPath == "" {
+		*configPath = os.Getenv("CONFIG_FILE")
+	}
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath); err != nil {
+			log.Printf("Failed to load configuration: %v", err)
+			os.Exit(exitConfig)
+		}
+	}
+
+	// Replace @kv: references with their Key Vault secrets before anything reads them
+	if err := resolveKeyVaultReferences(); err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		os.Exit(exitConfig)
+	}
+
+	// Load configuration into Config struct
+	config, err := loadConfig()
+	if err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		os.Exit(exitConfig)
+	}
+	if *dryRun {
+		config.DryRun = true
+	}
+	if *outputFile != "" {
+		config.OutputFile = *outputFile
+	}
+	if *skipPublish {
+		config.SkipPublish = true
+	}
+	if *skipInfra {
+		config.SkipInfra = true
+	}
+	// A subcommand such as `cleanup` takes precedence over MODE
+	if flag.NArg() > 0 {
+		config.Mode = flag.Arg(0)
+	}
+	if *cleanupOnly {
+		config.Mode = modeCleanup
+	}
+	if *listLocationsOnly {
+		config.Mode = modeListLocations
+	}
+	if *planOnly {
+		config.Mode = modePlan
+	}
+	if *ifAppExists != "" {
+		config.IfAppExists = strings.ToLower(*ifAppExists)
+	}
+	if *force {
+		if *ifAppExists != "" && config.IfAppExists != appExistsRecreate {
+			log.Printf("Failed to load configuration: --force conflicts with --if-app-exists=%s", *ifAppExists)
+			os.Exit(exitConfig)
+		}
+		config.IfAppExists = appExistsRecreate
+	}
+	if *exportARM != "" {
+		config.Mode = modeExportARM
+		config.ExportARM = *exportARM
+	}
+	if *confirmDelete {
+		config.AutoApprove = true
+	}
+	if config.DryRun {
+		// Prefix every subsequent log line so dry-run output is never mistaken for a real deployment
+		log.SetPrefix("[DRY-RUN] ")
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+	if err := setupLogging(config.LogFormat, config.DryRun); err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		os.Exit(exitConfig)
+	}
+	if config.DryRun {
+		log.Println("Dry-run mode enabled: no Azure resources will be created or deleted.")
+	}
+
+	// Cancel the run on SIGINT or SIGTERM so that resources created so far are rolled
+	// back. A second signal terminates the process immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("Shutdown requested, stopping after cleaning up. Press Ctrl-C again to exit immediately.")
+	}()
+
+	switch config.Mode {
+	case modeDeploy:
+		if _, err := DeployAll(ctx, config); err != nil {
+			log.Printf("Deployment failed: %v", err)
+			os.Exit(exitCode(ctx, err))
+		}
+	case modeCleanup:
+		if err := Cleanup(ctx, config); err != nil {
+			log.Printf("Cleanup failed: %v", err)
+			os.Exit(exitCode(ctx, err))
+		}
+	case modeListLocations:
+		if err := ListLocations(ctx, config); err != nil {
+			log.Printf("Listing locations failed: %v", err)
+			os.Exit(exitCode(ctx, err))
+		}
+	case modeExportARM:
+		if err := ExportARM(ctx, config); err != nil {
+			log.Printf("ARM template export failed: %v", err)
+			os.Exit(exitCode(ctx, err))
+		}
+	case modePlan:
+		if err := Plan(ctx, config); err != nil {
+			log.Printf("Plan failed: %v", err)
+			os.Exit(exitCode(ctx, err))
+		}
+	default:
+		log.Printf("Unknown mode %q, accepted values are: %s, %s, %s, %s, %s", config.Mode, modeDeploy, modeCleanup, modeListLocations, modeExportARM, modePlan)
+		os.Exit(exitConfig)
+	}
+}
+
+// Deploy validates the configuration and executes every deployment step in order.
+// The first failure is returned as a *StepError identifying the step that failed.
+// When ROLLBACK_ON_FAILURE is set, resources created before the failure are deleted
+func Deploy(ctx context.Context, config Config) (result DeploymentResult, err error) {
+	// Each step runs with its own context bounded by STEP_TIMEOUT
+	steps := stepTracker{parent: ctx, timeout: config.StepTimeout}
+	var stepCtx context.Context
+	var rollback rollbackStack
+	result.FunctionAppName = config.AzureFunctionAppName
+	defer func() {
+		steps.finish(err)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			err = fmt.Errorf("%w (STEP_TIMEOUT of %s exceeded)", err, config.StepTimeout)
+		}
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && config.DeployTimeout > 0 {
+			err = fmt.Errorf("%w (DEPLOY_TIMEOUT of %s exceeded)", err, config.DeployTimeout)
+		} else if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("%w (deployment interrupted)", err)
+		}
+		if err != nil && config.RollbackOnFailure && !config.DryRun && !config.KeepResource {
+			log.Println("Deployment failed, rolling back resources created by this run.")
+			rollback.run(ctx)
+		} else if err != nil && ctx.Err() != nil && len(rollback.actions) > 0 {
+			log.Println("Deployment interrupted, keeping the resources created so far because KEEP_RESOURCE is set or ROLLBACK_ON_FAILURE is disabled.")
+		}
+	}()
+
+	// Step 1: Validate required environment variables
+	steps.begin(StepValidateConfig, "")
+	if err := validateConfig(&config); err != nil {
+		return result, &StepError{Step: StepValidateConfig, Err: err}
+	}
+
+	// Step 2: Validate that required commands are available
+	steps.begin(StepCheckCommands, "az, func")
+	if !isCommandAvailable(commandPath(config, "az")) {
+		return result, &StepError{Step: StepCheckCommands, Err: fmt.Errorf("'%s' command is not available. Please install Azure CLI or set AZ_PATH", commandPath(config, "az"))}
+	}
+
+	if !isCommandAvailable(commandPath(config, "func")) {
+		return result, &StepError{Step: StepCheckCommands, Err: fmt.Errorf("'%s' command is not available. Please install Azure Functions Core Tools or set FUNC_PATH", commandPath(config, "func"))}
+	}
+
+	// Fail early when either CLI is too old for the Functions v4 commands used below
+	if err := checkCLIVersions(ctx, config); err != nil {
+		return result, &StepError{Step: StepCheckCommands, Err: err}
+	}
+
+	// Point the az CLI at the same cloud as the SDK clients
+	if err := setCLICloud(ctx, config); err != nil {
+		return result, &StepError{Step: StepCheckCommands, Err: err}
+	}
+
+	// Step 3: Initialize Azure SDK credentials
+	steps.begin(StepCredentials, "")
+	cred, err := newCredential(config)
+	if err != nil {
+		return result, &StepError{Step: StepCredentials, Err: err}
+	}
+
+	// Step 4: Initialize Azure SDK clients
+	steps.begin(StepInitClients, config.AzureSubscriptionID)
+	if err := initClients(config, cred); err != nil {
+		return result, &StepError{Step: StepInitClients, Err: err}
+	}
+
+	// Fail fast when not logged in instead of midway through the deployment
+	stepCtx = steps.begin(StepVerifyCredential, config.AzureSubscriptionID)
+	if err := verifyCredential(stepCtx, config); err != nil {
+		return result, &StepError{Step: StepVerifyCredential, Err: err}
+	}
+
+	// With SKIP_INFRA the resources were provisioned by an earlier run, so only the code
+	// is published
+	if config.SkipInfra {
+		if err := publishOnly(&steps, config, cred, &result); err != nil {
+			return result, err
+		}
+		steps.finish(nil)
+		return result, nil
+	}
+
+	// Catch a mistyped AZURE_LOCATION before the resource group is created in it
+	stepCtx = steps.begin(StepValidateLocation, config.AzureLocation)
+	if err := validateLocation(stepCtx, config); err != nil {
+		return result, &StepError{Step: StepValidateLocation, Err: err}
+	}
+
+	// Fail before provisioning when CHECK_QUOTA finds no room for another storage account
+	if config.CheckQuota {
+		stepCtx = steps.begin(StepCheckQuota, config.AzureLocation)
+		if err := checkStorageQuota(stepCtx, config); err != nil {
+			return result, &StepError{Step: StepCheckQuota, Err: err}
+		}
+	}
+
+	// Step 5: Create Resource Group, remembering whether it already existed so that a
+	// rollback never deletes a group this run did not create
+	stepCtx = steps.begin(StepCreateResourceGroup, config.AzureResourceGroupName)
+	resourceGroupExisted := false
+	// The check is read-only, so it also runs in dry-run mode when an existing group
+	// must be protected from cleanup
+	if !config.DryRun || config.ReuseResourceGroup != reuseGroupOff {
+		existence, err := resourceGroupClient.CheckExistence(stepCtx, config.AzureResourceGroupName, nil)
+		if err != nil {
+			return result, &StepError{Step: StepCreateResourceGroup, Err: err}
+		}
+		resourceGroupExisted = existence.Success
+	}
+
+	resourceGroup, err := withRetry(stepCtx, config, "create resource group", func() (*armresources.ResourceGroup, error) {
+		return createResourceGroup(stepCtx, config)
+	})
+	if err != nil {
+		return result, &StepError{Step: StepCreateResourceGroup, Err: err}
+	}
+	log.Println("Resource Group Created:", *resourceGroup.ID)
+	result.ResourceGroupID = *resourceGroup.ID
+	if !resourceGroupExisted {
+		rollback.push(*resourceGroup.ID, func(ctx context.Context) error {
+			return deleteResourceGroup(ctx, config)
+		})
+	}
+
+	// Steps 6 to 8: Provision the Storage Account, Application Insights and the hosting
+	// plan, which only depend on the resource group
+	if err := provisionResources(&steps, config, &rollback, &result); err != nil {
+		return result, err
+	}
+
+	// Steps 9 and 10: Initialize the Function App project and create the functions,
+	// unless SKIP_PUBLISH leaves the code to a later stage or it ships in CONTAINER_IMAGE
+	if !config.SkipPublish && config.ContainerImage == "" {
+		if err := prepareFunctionProject(&steps, config, &result); err != nil {
+			return result, err
+		}
+	}
+
+	// Step 11: Execute Azure CLI Commands to Create the Function App. An existing one
+	// stops the run unless IF_APP_EXISTS asks for it to be updated, so that re-running
+	// redeploys the code, or recreated
+	stepCtx = steps.begin(StepCreateFunctionApp, config.AzureFunctionAppName)
+	exists, err := functionAppExists(stepCtx, config)
+	if err != nil {
+		return result, &StepError{Step: StepCreateFunctionApp, Err: err}
+	}
+	if exists && config.IfAppExists == appExistsFail {
+		return result, &StepError{Step: StepCreateFunctionApp, Err: fmt.Errorf(
+			"Function App %s already exists in resource group %s, re-run with --if-app-exists=%s or %s (IF_APP_EXISTS) to deploy over it",
+			config.AzureFunctionAppName, config.AzureResourceGroupName, appExistsUpdate, appExistsRecreate)}
+	}
+	if exists && config.IfAppExists == appExistsRecreate {
+		log.Println("Function App already exists, deleting it to recreate it:", functionAppID(config))
+		if config.DryRun {
+			planDryRun("delete existing Function App %s to recreate it", config.AzureFunctionAppName)
+		} else if err := deleteFunctionApp(stepCtx, config); err != nil {
+			return result, &StepError{Step: StepCreateFunctionApp, Err: err}
+		}
+		exists = false
+	}
+	var site functionAppSite
+	if exists {
+		if site, err = updateFunctionApp(stepCtx, config); err != nil {
+			return result, &StepError{Step: StepCreateFunctionApp, Err: err}
+		}
+		log.Println("Function App already exists, updated it instead of creating it:", functionAppID(config))
+		result.FunctionAppUpdated = true
+		result.OSType = site.osType()
+	} else {
+		if site, err = createFunctionApp(stepCtx, config); err != nil {
+			return result, &StepError{Step: StepCreateFunctionApp, Err: err}
+		}
+		log.Println("Function App Created Successfully.")
+		result.OSType = functionAppOS(config)
+		rollback.push(functionAppID(config), func(ctx context.Context) error {
+			return deleteFunctionApp(ctx, config)
+		})
+	}
+	result.FunctionAppID = functionAppID(config)
+	result.DefaultHostName = site.DefaultHostName
+	if site.Identity != nil {
+		result.PrincipalID = site.Identity.PrincipalID
+	}
+
+	// A new app was created with the managed identities, an existing one gets them
+	// assigned
+	if exists && len(identitiesToAssign(config)) > 0 {
+		stepCtx = steps.begin(StepManagedIdentity, config.AzureFunctionAppName)
+		principalID, err := assignManagedIdentity(stepCtx, config)
+		if err != nil {
+			return result, &StepError{Step: StepManagedIdentity, Err: err}
+		}
+		if principalID != "" {
+			result.PrincipalID = principalID
+		}
+	}
+	result.UserAssignedIdentityID = config.UserAssignedIdentityID
+
+	// Allow browser clients from CORS_ORIGINS to call the functions
+	origins, err := parseCORSOrigins(config.AllowedOrigins)
+	if err != nil {
+		return result, &StepError{Step: StepCORS, Err: err}
+	}
+	if len(origins) > 0 {
+		stepCtx = steps.begin(StepCORS, config.AzureFunctionAppName)
+		if err := configureCORS(stepCtx, config, origins); err != nil {
+			return result, &StepError{Step: StepCORS, Err: err}
+		}
+		result.AllowedOrigins = origins
+	}
+
+	// Apply APP_SETTINGS and APP_SETTINGS_FILE to the new Function App
+	settings, err := parseAppSettings(config)
+	if err != nil {
+		return result, &StepError{Step: StepAppSettings, Err: err}
+	}
+	if len(settings) > 0 {
+		stepCtx = steps.begin(StepAppSettings, config.AzureFunctionAppName)
+		if err := configureAppSettings(stepCtx, config, settings); err != nil {
+			return result, &StepError{Step: StepAppSettings, Err: err}
+		}
+	}
+
+	// Step 12: Publish Function App
+	if config.SkipPublish {
+		log.Println("Skipping publish: SKIP_PUBLISH is set, the Function App was provisioned without code.")
+	} else if config.ContainerImage != "" {
+		log.Println("Skipping publish: the code ships in CONTAINER_IMAGE", config.ContainerImage)
+	} else if err := publishFunctions(&steps, config, cred, &result); err != nil {
+		return result, err
+	}
+
+	// Step 13: Cleanup Resources if KEEP_RESOURCE is not set. A resource group reused
+	// with REUSE_RESOURCE_GROUP was not created by this run, so it is never deleted
+	if config.ReuseResourceGroup != reuseGroupOff && resourceGroupExisted && !config.KeepResource {
+		log.Printf("Skipping cleanup: resource group %s is reused and will not be deleted.", config.AzureResourceGroupName)
+	} else if !config.KeepResource {
+		// Close the publish step so its timeout does not run while waiting for the user
+		steps.finish(nil)
+		confirmed, err := confirmDeletion(config, false)
+		if err != nil {
+			return result, &StepError{Step: StepCleanup, Err: err}
+		}
+		if confirmed {
+			stepCtx = steps.begin(StepCleanup, config.AzureResourceGroupName)
+			if err := cleanup(stepCtx, config); err != nil {
+				return result, &StepError{Step: StepCleanup, Err: err}
+			}
+			log.Println("Resources cleaned up successfully.")
+			result.CleanedUp = true
+		} else {
+			log.Println("Cleanup cancelled, resources were kept.")
+		}
+	}
+
+	// Step 14: Write the deployment summary if OUTPUT_FILE is set
+	if err := writeResult(&steps, config, &result); err != nil {
+		return result, err
+	}
+
+	steps.finish(nil)
+	return result, nil
+}
+
+// publishOnly deploys the code to a Function App provisioned by an earlier run, for
+// SKIP_INFRA. No Azure resources are created, so nothing is rolled back or cleaned up
+func publishOnly(steps *stepTracker, config Config, cred azcore.TokenCredential, result *DeploymentResult) error {
+	stepCtx := steps.begin(StepCheckFunctionApp, config.AzureFunctionAppName)
+	site, err := showFunctionApp(stepCtx, config)
+	if err != nil {
+		return &StepError{Step: StepCheckFunctionApp, Err: fmt.Errorf("%w (provision the Function App first or unset SKIP_INFRA)", err)}
+	}
+	log.Println("Publishing to existing Function App:", functionAppID(config))
+	result.FunctionAppID = functionAppID(config)
+	result.OSType = site.osType()
+	if config.AzureOSType != "" && result.OSType != "" && result.OSType != config.AzureOSType {
+		log.Printf("Warning: OS_TYPE is %s but Function App %s runs on %s", config.AzureOSType, config.AzureFunctionAppName, result.OSType)
+	}
+	result.DefaultHostName = site.DefaultHostName
+	if site.Identity != nil {
+		result.PrincipalID = site.Identity.PrincipalID
+	}
+
+	if err := prepareFunctionProject(steps, config, result); err != nil {
+		return err
+	}
+	if err := publishFunctions(steps, config, cred, result); err != nil {
+		return err
+	}
+	return writeResult(steps, config, result)
+}
+
+// provisionResources creates the Storage Account, the Application Insights component
+// and the hosting plan. None of them depends on another, so with PARALLEL they are
+// created concurrently, each step tracked on its own, and the first failure cancels
+// the others. Otherwise they are created one after the other on steps
+func provisionResources(steps *stepTracker, config Config, rollback *rollbackStack, result *DeploymentResult) error {
+	tasks := []func(steps *stepTracker) error{
+		func(steps *stepTracker) error { return provisionStorage(steps, config, rollback, result) },
+		func(steps *stepTracker) error { return provisionAppInsights(steps, config, rollback, result) },
+		func(steps *stepTracker) error { return provisionHostingPlan(steps, config, rollback, result) },
+	}
+	if !config.Parallel {
+		for _, task := range tasks {
+			if err := task(steps); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	steps.finish(nil)
+	g, gctx := errgroup.WithContext(steps.parent)
+	for _, task := range tasks {
+		g.Go(func() error {
+			tracker := stepTracker{parent: gctx, timeout: steps.timeout}
+			err := task(&tracker)
+			tracker.finish(err)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// provisionStorage creates the Storage Account, or reuses it when it already exists,
+// reads its properties and creates the BLOB_CONTAINERS. The name availability check
+// gates the creation
+func provisionStorage(steps *stepTracker, config Config, rollback *rollbackStack, result *DeploymentResult) error {
+	// Step 6: Check Storage Account Name Availability, reusing the account if this
+	// resource group already owns it
+	stepCtx := steps.begin(StepCheckStorageName, config.AzureStorageAccountName)
+	storageAccount, err := findExistingStorageAccount(stepCtx, config)
+	if err != nil {
+		return &StepError{Step: StepCheckStorageName, Err: err}
+	}
+	if storageAccount != nil && (config.BlobSoftDeleteDays > 0 || config.BlobVersioning) {
+		log.Printf("Warning: storage account %s already exists, BLOB_SOFT_DELETE_DAYS and BLOB_VERSIONING are not applied to it",
+			config.AzureStorageAccountName)
+	}
+	if storageAccount != nil && (config.EnableHNS || config.EnableSFTP || config.EnableLargeFileShares) {
+		log.Printf("Warning: storage account %s already exists, ENABLE_HIERARCHICAL_NAMESPACE, ENABLE_SFTP and ENABLE_LARGE_FILE_SHARES are not applied to it",
+			config.AzureStorageAccountName)
+	}
+	if config.UseExistingStorageAccount {
+		if err := checkExistingStorageAccount(stepCtx, config, storageAccount); err != nil {
+			return &StepError{Step: StepCheckStorageName, Err: err}
+		}
+		log.Println("Using existing Storage Account:", *storageAccount.ID)
+	} else if storageAccount != nil {
+		if !sameLocation(*storageAccount.Location, config.AzureLocation) {
+			return &StepError{Step: StepCheckStorageName, Err: fmt.Errorf(
+				"storage account %s already exists in resource group %s but in location %s instead of %s",
+				config.AzureStorageAccountName, config.AzureResourceGroupName, *storageAccount.Location, config.AzureLocation)}
+		}
+		log.Println("Reusing existing Storage Account, skipping creation:", *storageAccount.ID)
+	} else {
+		availability, err := withRetry(stepCtx, config, "check storage account name availability", func() (*armstorage.CheckNameAvailabilityResult, error) {
+			return checkNameAvailability(stepCtx, config)
+		})
+		if err != nil {
+			return &StepError{Step: StepCheckStorageName, Err: err}
+		}
+		if !*availability.NameAvailable {
+			// The account is not in the configured resource group, so the name is owned
+			// by another resource group, subscription or tenant
+			return &StepError{Step: StepCheckStorageName, Err: fmt.Errorf(
+				"storage account name is not available (it is not in resource group %s): %s",
+				config.AzureResourceGroupName, *availability.Message)}
+		}
+
+		// Step 7: Create Storage Account
+		stepCtx = steps.begin(StepCreateStorageAccount, config.AzureStorageAccountName)
+		storageAccount, err = withRetry(stepCtx, config, "create storage account", func() (*armstorage.Account, error) {
+			return createStorageAccount(stepCtx, config)
+		})
+		if err != nil {
+			return &StepError{Step: StepCreateStorageAccount, Err: err}
+		}
+		log.Println("Storage Account Created:", *storageAccount.ID)
+		rollback.push(*storageAccount.ID, func(ctx context.Context) error {
+			return deleteStorageAccount(ctx, config)
+		})
+
+		// Enable blob soft delete and versioning on the new account when configured
+		if config.BlobSoftDeleteDays > 0 || config.BlobVersioning {
+			stepCtx = steps.begin(StepBlobDataProtection, config.AzureStorageAccountName)
+			_, err = withRetry(stepCtx, config, "configure blob data protection", func() (*armstorage.BlobServiceProperties, error) {
+				return configureBlobDataProtection(stepCtx, config)
+			})
+			if err != nil {
+				return &StepError{Step: StepBlobDataProtection, Err: err}
+			}
+		}
+	}
+
+	// Step 8: Get Storage Account Properties
+	stepCtx = steps.begin(StepStorageProperties, config.AzureStorageAccountName)
+	properties, err := withRetry(stepCtx, config, "get storage account properties", func() (*armstorage.Account, error) {
+		return storageAccountProperties(stepCtx, config)
+	})
+	if err != nil {
+		return &StepError{Step: StepStorageProperties, Err: err}
+	}
+	log.Println("Storage Account Properties ID:", *properties.ID)
+	result.StorageAccountID = *properties.ID
+	result.StorageEndpoints = storageEndpointsFrom(properties)
+
+	// Read an access key for the connection string; accounts with shared key access
+	// disabled have none, so a failure is only a warning
+	connectionString := ""
+	if config.DryRun {
+		planDryRun("list the access keys of storage account %s", config.AzureStorageAccountName)
+	} else {
+		connectionString, err = storageConnectionString(stepCtx, config, properties)
+		if err != nil {
+			log.Println("Warning:", err)
+		}
+	}
+	logStorageAccess(config, result.StorageEndpoints, connectionString)
+	if config.ShowSecrets {
+		result.StorageConnectionString = connectionString
+	}
+	if config.ExportConnectionString {
+		steps.begin(StepExportConnection, config.ConnectionStringOutput)
+		if err := exportConnectionString(config, connectionString); err != nil {
+			return &StepError{Step: StepExportConnection, Err: err}
+		}
+	}
+
+	// Create the BLOB_CONTAINERS that do not exist yet
+	if config.BlobContainers != "" {
+		stepCtx = steps.begin(StepBlobContainers, config.AzureStorageAccountName)
+		containerIDs, err := createBlobContainers(stepCtx, config)
+		for _, id := range containerIDs {
+			rollback.push(id, func(ctx context.Context) error {
+				return deleteBlobContainer(ctx, config, id)
+			})
+		}
+		result.BlobContainerIDs = containerIDs
+		if err != nil {
+			return &StepError{Step: StepBlobContainers, Err: err}
+		}
+	}
+	return nil
+}
+
+// provisionAppInsights creates the Application Insights component the Function App
+// reports to, if enabled, or reuses the one that already exists
+func provisionAppInsights(steps *stepTracker, config Config, rollback *rollbackStack, result *DeploymentResult) error {
+	if !config.EnableAppInsights {
+		return nil
+	}
+	stepCtx := steps.begin(StepCreateAppInsights, config.AppInsightsName)
+	exists, err := appInsightsExists(stepCtx, config)
+	if err != nil {
+		return &StepError{Step: StepCreateAppInsights, Err: err}
+	}
+	// An existing component is left as is and never rolled back
+	if exists {
+		log.Println("Reusing existing Application Insights component, skipping creation:", appInsightsID(config))
+		result.AppInsightsID = appInsightsID(config)
+		return nil
+	}
+	component, err := createAppInsights(stepCtx, config)
+	if err != nil {
+		return &StepError{Step: StepCreateAppInsights, Err: err}
+	}
+	log.Println("Application Insights Created Successfully:", component.ID)
+	result.AppInsightsID = component.ID
+	rollback.push(component.ID, func(ctx context.Context) error {
+		return deleteAppInsights(ctx, config)
+	})
+	return nil
+}
+
+// provisionHostingPlan creates the premium or dedicated hosting plan. Consumption apps
+// get theirs from Azure when the Function App is created
+func provisionHostingPlan(steps *stepTracker, config Config, rollback *rollbackStack, result *DeploymentResult) error {
+	if config.PlanType == planTypeConsumption {
+		return nil
+	}
+	stepCtx := steps.begin(StepCreateHostingPlan, config.PlanName)
+	if err := createHostingPlan(stepCtx, config); err != nil {
+		return &StepError{Step: StepCreateHostingPlan, Err: err}
+	}
+	log.Println("Hosting Plan Created Successfully:", config.PlanName)
+	result.HostingPlanID = hostingPlanID(config)
+	rollback.push(hostingPlanID(config), func(ctx context.Context) error {
+		return deleteHostingPlan(ctx, config)
+	})
+	return nil
+}
+
+// prepareFunctionProject initializes the Function App project if needed and creates
+// the configured functions in it
+func prepareFunctionProject(steps *stepTracker, config Config, result *DeploymentResult) error {
+	stepCtx := steps.begin(StepInitProject, config.FunctionProjectDir)
+	if err := initializeFunctionProject(stepCtx, config); err != nil {
+		return &StepError{Step: StepInitProject, Err: err}
+	}
+	log.Println("Function App Project Initialized Successfully.")
+
+	// Create the functions using `func new`
+	stepCtx = steps.begin(StepCreateFunction, config.AzureFunctionAppName)
+	functions, err := createFunctions(stepCtx, config)
+	result.Functions = functions
+	if err != nil {
+		return &StepError{Step: StepCreateFunction, Err: err}
+	}
+	log.Println("Functions Created Successfully.")
+	return nil
+}
+
+// publishFunctions publishes the project to the Function App and, unless
+// VERIFY_DEPLOYMENT is disabled, waits until its functions are live
+func publishFunctions(steps *stepTracker, config Config, cred azcore.TokenCredential, result *DeploymentResult) error {
+	stepCtx := steps.begin(StepPublish, config.AzureFunctionAppName)
+	var err error
+	switch config.PublishMode {
+	case publishModeZipDeploy:
+		err = publishViaZipDeploy(stepCtx, config, cred)
+	case publishModeRunFromPackage:
+		err = publishViaRunFromPackage(stepCtx, config, result)
+	default:
+		err = publishFunctionApp(stepCtx, config)
+	}
+	if err != nil {
+		return &StepError{Step: StepPublish, Err: err}
+	}
+	log.Println("Function App Published Successfully.")
+
+	// Wait for the host to load the published functions, so a function that silently
+	// failed to deploy fails the run
+	if config.VerifyDeployment {
+		stepCtx = steps.begin(StepVerifyFunctions, config.AzureFunctionAppName)
+		if err := verifyFunctions(stepCtx, config, result.Functions); err != nil {
+			return &StepError{Step: StepVerifyFunctions, Err: err}
+		}
+		if !config.DryRun {
+			log.Println("All functions are live.")
+		}
+	}
+	return nil
+}
+
+// writeResult stamps the deployment time and writes the result to OUTPUT_FILE, if set
+func writeResult(steps *stepTracker, config Config, result *DeploymentResult) error {
+	result.DeployedAt = time.Now().UTC()
+	if config.OutputFile == "" {
+		return nil
+	}
+	steps.begin(StepWriteResult, config.OutputFile)
+	if err := writeDeploymentResult(config.OutputFile, *result); err != nil {
+		return &StepError{Step: StepWriteResult, Err: err}
+	}
+	log.Println("Deployment result written to", config.OutputFile)
+	return nil
+}
+
+// loadConfig retrieves environment variables and populates the Config struct
+func loadConfig() (Config, error) {
+	functionRuntime := getEnvOrDefault("FUNCTION_RUNTIME", "node")
+
+	cfg := Config{
+		AzureSubscriptionID:       os.Getenv("AZURE_SUBSCRIPTION_ID"),
+		AzureLocation:             os.Getenv("AZURE_LOCATION"),
+		AzureResourceGroupName:    os.Getenv("AZURE_RESOURCE_GROUP_NAME"),
+		AzureStorageAccountName:   os.Getenv("AZURE_STORAGE_ACCOUNT_NAME"),
+		AzureFunctionAppName:      os.Getenv("AZURE_FUNCTION_APP_NAME"),
+		FunctionName:              os.Getenv("FUNCTION_NAME"),
+		Functions:                 os.Getenv("FUNCTIONS"),
+		Deployments:               os.Getenv("DEPLOYMENTS"),
+		FunctionTemplate:          os.Getenv("FUNCTION_TEMPLATE"),
+		AuthLevel:                 os.Getenv("AUTH_LEVEL"),
+		SkipTemplateValidation:    getEnvBool("SKIP_TEMPLATE_VALIDATION", false),
+		KeepResource:              getEnvBool("KEEP_RESOURCE", false),
+		FunctionProjectDir:        os.Getenv("FUNCTION_PROJECT_DIR"),
+		DryRun:                    getEnvBool("DRY_RUN", false),
+		StorageSKU:                getEnvOrDefault("STORAGE_SKU", string(armstorage.SKUNameStandardLRS)),
+		StorageAccessTier:         getEnvOrDefault("ACCESS_TIER", string(armstorage.AccessTierHot)),
+		StorageKind:               getEnvOrDefault("STORAGE_KIND", string(armstorage.KindStorageV2)),
+		StoragePremium:            getEnvBool("STORAGE_PREMIUM", false),
+		StorageMinTLS:             getEnvOrDefault("MIN_TLS_VERSION", string(armstorage.MinimumTLSVersionTLS12)),
+		StorageHTTPSOnly:          getEnvBool("HTTPS_ONLY", true),
+		BlobVersioning:            getEnvBool("BLOB_VERSIONING", false),
+		EnableHNS:                 getEnvBool("ENABLE_HIERARCHICAL_NAMESPACE", false),
+		EnableSFTP:                getEnvBool("ENABLE_SFTP", false),
+		EnableLargeFileShares:     getEnvBool("ENABLE_LARGE_FILE_SHARES", false),
+		EncryptionKeySource:       getEnvOrDefault("ENCRYPTION_KEY_SOURCE", string(armstorage.KeySourceMicrosoftStorage)),
+		EncryptionKeyVaultURI:     os.Getenv("ENCRYPTION_KEY_VAULT_URI"),
+		EncryptionIdentityID:      os.Getenv("ENCRYPTION_IDENTITY_ID"),
+		EncryptionServices:        getEnvOrDefault("ENCRYPTION_SERVICES", strings.Join(encryptionServiceNames, ",")),
+		NetworkDefaultAction:      getEnvOrDefault("NETWORK_DEFAULT_ACTION", string(armstorage.DefaultActionAllow)),
+		AllowedIPRanges:           os.Getenv("ALLOWED_IP_RANGES"),
+		AllowedSubnetIDs:          os.Getenv("ALLOWED_SUBNET_IDS"),
+		BlobContainers:            os.Getenv("BLOB_CONTAINERS"),
+		BlobContainerPublicAccess: getEnvOrDefault("BLOB_CONTAINER_PUBLIC_ACCESS", string(armstorage.PublicAccessNone)),
+		FunctionRuntime:           functionRuntime,
+		AzureOSType:               strings.ToLower(os.Getenv("OS_TYPE")),
+		FuncProgrammingModel:      strings.ToUpper(os.Getenv("FUNC_PROGRAMMING_MODEL")),
+		FuncLanguage:              strings.ToLower(os.Getenv("FUNC_LANGUAGE")),
+		ContainerImage:            os.Getenv("CONTAINER_IMAGE"),
+		RegistryServer:            os.Getenv("REGISTRY_SERVER"),
+		RegistryUsername:          os.Getenv("REGISTRY_USERNAME"),
+		RegistryPassword:          os.Getenv("REGISTRY_PASSWORD"),
+		FunctionRuntimeVersion:    getEnvOrDefault("FUNCTION_RUNTIME_VERSION", defaultRuntimeVersions[functionRuntime]),
+		FunctionsVersion:          getEnvOrDefault("FUNCTIONS_VERSION", "4"),
+		PublishMode:               strings.ToLower(getEnvOrDefault("PUBLISH_MODE", publishModeFunc)),
+		ResourceTags:              os.Getenv("RESOURCE_TAGS"),
+		UseExistingStorageAccount: getEnvBool("USE_EXISTING_STORAGE_ACCOUNT", false),
+		StorageResourceGroup:      os.Getenv("STORAGE_RESOURCE_GROUP"),
+		RollbackOnFailure:         getEnvBool("ROLLBACK_ON_FAILURE", true),
+		VerifyDeployment:          getEnvBool("VERIFY_DEPLOYMENT", true),
+		SkipPublish:               getEnvBool("SKIP_PUBLISH", false),
+		SkipInfra:                 getEnvBool("SKIP_INFRA", false),
+		UpdateRuntimeVersion:      getEnvBool("UPDATE_RUNTIME_VERSION", false),
+		IfAppExists:               strings.ToLower(getEnvOrDefault("IF_APP_EXISTS", appExistsFail)),
+		Parallel:                  getEnvBool("PARALLEL", false),
+		CheckQuota:                getEnvBool("CHECK_QUOTA", false),
+		AllowedOrigins:            os.Getenv("CORS_ORIGINS"),
+		PlanType:                  strings.ToLower(getEnvOrDefault("PLAN_TYPE", planTypeConsumption)),
+		PlanSKU:                   strings.ToUpper(os.Getenv("PLAN_SKU")),
+		PlanName:                  getEnvOrDefault("PLAN_NAME", os.Getenv("AZURE_FUNCTION_APP_NAME")+"-plan"),
+		Mode:                      strings.ToLower(getEnvOrDefault("MODE", modeDeploy)),
+		AutoApprove:               getEnvBool("AUTO_APPROVE", false) || getEnvBool("CONFIRM_DELETE", false),
+		AppSettings:               os.Getenv("APP_SETTINGS"),
+		AppSettingsFile:           os.Getenv("APP_SETTINGS_FILE"),
+		EnableAppInsights:         getEnvBool("ENABLE_APP_INSIGHTS", false),
+		AssignIdentity:            getEnvBool("ASSIGN_IDENTITY", false),
+		UserAssignedIdentityID:    os.Getenv("USER_ASSIGNED_IDENTITY_ID"),
+		AppInsightsName:           getEnvOrDefault("APP_INSIGHTS_NAME", os.Getenv("AZURE_FUNCTION_APP_NAME")+"-insights"),
+		AzPath:                    os.Getenv("AZ_PATH"),
+		MinAzVersion:              getEnvOrDefault("MIN_AZ_VERSION", defaultMinAzVersion),
+		MinFuncVersion:            getEnvOrDefault("MIN_FUNC_VERSION", defaultMinFuncVersion),
+		FuncPath:                  os.Getenv("FUNC_PATH"),
+		LogFormat:                 strings.ToLower(getEnvOrDefault("LOG_FORMAT", logFormatText)),
+		Verbose:                   getEnvBool("VERBOSE", false),
+		OutputFile:                os.Getenv("OUTPUT_FILE"),
+		ShowSecrets:               getEnvBool("SHOW_SECRETS", false),
+		ExportConnectionString:    getEnvBool("EXPORT_CONNECTION_STRING", false),
+		ConnectionStringOutput:    os.Getenv("CONNECTION_STRING_OUTPUT"),
+		AzureCloud:                getEnvOrDefault("AZURE_CLOUD", defaultAzureCloud),
+		AuthMethod:                strings.ToLower(getEnvOrDefault("AUTH_METHOD", authMethodDefault)),
+		ClientID:                  os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:              os.Getenv("AZURE_CLIENT_SECRET"),
+		TenantID:                  os.Getenv("AZURE_TENANT_ID"),
+	}
+
+	if cfg.StoragePremium {
+		applyStoragePremium(&cfg)
+	}
+
+	reuseResourceGroup, err := parseReuseResourceGroup(os.Getenv("REUSE_RESOURCE_GROUP"), os.Getenv("USE_EXISTING_RESOURCE_GROUP"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ReuseResourceGroup = reuseResourceGroup
+
+	cliTimeout, err := getEnvDuration("CLI_TIMEOUT", defaultCLITimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.CLITimeout = cliTimeout
+
+	stepTimeout, err := getEnvDuration("STEP_TIMEOUT", defaultStepTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.StepTimeout = stepTimeout
+
+	deployTimeout, err := getEnvDuration("DEPLOY_TIMEOUT", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.DeployTimeout = deployTimeout
+
+	verifyTimeout, err := getEnvDuration("VERIFY_TIMEOUT", defaultVerifyTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.VerifyTimeout = verifyTimeout
+
+	blobSoftDeleteDays, err := getEnvInt("BLOB_SOFT_DELETE_DAYS", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.BlobSoftDeleteDays = blobSoftDeleteDays
+
+	maxRetries, err := getEnvInt("MAX_RETRIES", defaultMaxRetries)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MaxRetries = maxRetries
+
+	retryBaseDelay, err := getEnvDuration("RETRY_BASE_DELAY", defaultRetryBaseDelay)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.RetryBaseDelay = retryBaseDelay
+
+	deployConcurrency, err := getEnvInt("DEPLOY_CONCURRENCY", defaultDeployConcurrency)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.DeployConcurrency = deployConcurrency
+
+	progressInterval, err := getEnvDuration("PROGRESS_INTERVAL", defaultProgressInterval)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ProgressInterval = progressInterval
+
+	pollFrequency, err := getEnvDuration("POLL_FREQUENCY", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	if pollFrequency != 0 && pollFrequency < minPollFrequency {
+		return Config{}, fmt.Errorf("invalid POLL_FREQUENCY %q: must be at least %s", os.Getenv("POLL_FREQUENCY"), minPollFrequency)
+	}
+	cfg.PollFrequency = pollFrequency
+
+	return cfg, nil
+}
+
+// getEnvInt parses the environment variable as a non-negative integer, or returns def
+// when it is empty
+func getEnvInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, value)
+	}
+	return n, nil
+}
+
+// getEnvDuration parses the environment variable as a duration such as "10m", or
+// returns def when it is empty
+func getEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be positive", key, value)
+	}
+	return d, nil
+}
+
+// getEnvOrDefault returns the value of the environment variable, or def when it is empty
+func getEnvOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvBool parses the environment variable as a boolean, or returns def when it is
+// empty. Unrecognized values are logged as a warning instead of being silently ignored
+func getEnvBool(key string, def bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	b, err := parseBool(value)
+	if err != nil {
+		log.Printf("Warning: unrecognized %s value %q, using %t (accepted: true/false, 1/0, yes/no, y/n, on/off)", key, value, def)
+		return def
+	}
+	return b
+}
+
+// validateConfig checks that all required environment variables are set and
+// resolves the Function App project directory to an absolute path
+func validateConfig(cfg *Config) error {
+	missingVars := []string{}
+
+	if cfg.AzureSubscriptionID == "" {
+		missingVars = append(missingVars, "AZURE_SUBSCRIPTION_ID")
+	}
+	if cfg.AzureLocation == "" {
+		missingVars = append(missingVars, "AZURE_LOCATION")
+	}
+	if cfg.AzureResourceGroupName == "" {
+		missingVars = append(missingVars, "AZURE_RESOURCE_GROUP_NAME")
+	}
+	if cfg.AzureStorageAccountName == "" {
+		missingVars = append(missingVars, "AZURE_STORAGE_ACCOUNT_NAME")
+	}
+	if cfg.AzureFunctionAppName == "" {
+		missingVars = append(missingVars, "AZURE_FUNCTION_APP_NAME")
+	}
+	// A FUNCTIONS manifest replaces the FUNCTION_NAME and FUNCTION_TEMPLATE lists
+	if cfg.Functions == "" {
+		if cfg.FunctionName == "" {
+			missingVars = append(missingVars, "FUNCTION_NAME")
+		}
+		if cfg.FunctionTemplate == "" {
+			missingVars = append(missingVars, "FUNCTION_TEMPLATE")
+		}
+		if cfg.AuthLevel == "" {
+			missingVars = append(missingVars, "AUTH_LEVEL")
+		}
+	}
+
+	if len(missingVars) > 0 {
+		return fmt.Errorf("missing required environment variables: %v (set them in the environment, .env or a --config file; %s)",
+			missingVars, configPrecedence)
+	}
+
+	if cfg.SkipPublish && cfg.SkipInfra {
+		return errors.New("SKIP_PUBLISH and SKIP_INFRA are mutually exclusive, set at most one of them (neither deploys everything)")
+	}
+	if cfg.SkipPublish && !cfg.KeepResource {
+		log.Println("Warning: SKIP_PUBLISH is set without KEEP_RESOURCE, the provisioned resources will be offered for cleanup at the end of the run")
+	}
+	if err := validateContainer(*cfg); err != nil {
+		return err
+	}
+	if cfg.ExportConnectionString && cfg.ConnectionStringOutput == "" {
+		return errors.New("EXPORT_CONNECTION_STRING requires CONNECTION_STRING_OUTPUT, the file to write the connection string to")
+	}
+	if !cfg.ExportConnectionString && cfg.ConnectionStringOutput != "" {
+		log.Println("Warning: CONNECTION_STRING_OUTPUT is ignored unless EXPORT_CONNECTION_STRING is set")
+	}
+
+	if location := normalizeLocation(cfg.AzureLocation); location != cfg.AzureLocation {
+		log.Printf("Normalized AZURE_LOCATION %q to %q", cfg.AzureLocation, location)
+		cfg.AzureLocation = location
+	}
+	if err := validateNames(*cfg); err != nil {
+		return err
+	}
+	if cfg.StorageResourceGroup != "" {
+		if !cfg.UseExistingStorageAccount {
+			return errors.New("STORAGE_RESOURCE_GROUP requires USE_EXISTING_STORAGE_ACCOUNT, a new storage account is always created in AZURE_RESOURCE_GROUP_NAME")
+		}
+		if !resourceGroupNamePattern.MatchString(cfg.StorageResourceGroup) {
+			return fmt.Errorf("invalid STORAGE_RESOURCE_GROUP %q", cfg.StorageResourceGroup)
+		}
+	}
+	sku, err := parseStorageSKU(cfg.StorageSKU)
+	if err != nil {
+		return fmt.Errorf("invalid STORAGE_SKU: %w", err)
+	}
+	kind, err := parseStorageKind(cfg.StorageKind)
+	if err != nil {
+		return fmt.Errorf("invalid STORAGE_KIND: %w", err)
+	}
+	if err := validateStorageKind(kind, sku); err != nil {
+		return err
+	}
+	if kind == armstorage.KindFileStorage && (cfg.BlobSoftDeleteDays > 0 || cfg.BlobVersioning) {
+		return fmt.Errorf("STORAGE_KIND %s has no blob service, unset BLOB_SOFT_DELETE_DAYS and BLOB_VERSIONING", kind)
+	}
+	if err := validateStorageFeatures(*cfg, kind, sku); err != nil {
+		return err
+	}
+	if _, err := parseAccessTier(cfg.StorageAccessTier); err != nil {
+		return fmt.Errorf("invalid ACCESS_TIER: %w", err)
+	}
+	minTLSVersion, err := parseMinTLSVersion(cfg.StorageMinTLS)
+	if err != nil {
+		return fmt.Errorf("invalid MIN_TLS_VERSION: %w", err)
+	}
+	if minTLSVersion == armstorage.MinimumTLSVersionTLS10 || minTLSVersion == armstorage.MinimumTLSVersionTLS11 {
+		log.Printf("Warning: MIN_TLS_VERSION %s is below the recommended %s", minTLSVersion, armstorage.MinimumTLSVersionTLS12)
+	}
+	if err := validateEncryption(*cfg); err != nil {
+		return err
+	}
+	if err := validateNetworkRules(*cfg); err != nil {
+		return err
+	}
+	if _, err := parseBlobContainers(cfg.BlobContainers); err != nil {
+		return fmt.Errorf("invalid BLOB_CONTAINERS: %w", err)
+	}
+	if _, err := parsePublicAccess(cfg.BlobContainerPublicAccess); err != nil {
+		return fmt.Errorf("invalid BLOB_CONTAINER_PUBLIC_ACCESS: %w", err)
+	}
+	if cfg.BlobSoftDeleteDays != 0 && (cfg.BlobSoftDeleteDays < 1 || cfg.BlobSoftDeleteDays > 365) {
+		return fmt.Errorf("invalid BLOB_SOFT_DELETE_DAYS %d: must be between 1 and 365, or 0 to disable soft delete", cfg.BlobSoftDeleteDays)
+	}
+	if !cfg.StorageHTTPSOnly {
+		log.Println("Warning: HTTPS_ONLY is disabled, the storage account will accept plain HTTP traffic")
+	}
+	if !slices.Contains(supportedFunctionRuntimes, cfg.FunctionRuntime) {
+		return fmt.Errorf("invalid FUNCTION_RUNTIME %q, accepted values are: %s",
+			cfg.FunctionRuntime, strings.Join(supportedFunctionRuntimes, ", "))
+	}
+	if err := validateFuncInit(*cfg); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{appExistsFail, appExistsUpdate, appExistsRecreate}, cfg.IfAppExists) {
+		return fmt.Errorf("invalid IF_APP_EXISTS %q, accepted values are: %s, %s, %s", cfg.IfAppExists, appExistsFail, appExistsUpdate, appExistsRecreate)
+	}
+	switch cfg.AzureOSType {
+	case "", osTypeLinux:
+	case osTypeWindows:
+		if slices.Contains(linuxOnlyRuntimes, cfg.FunctionRuntime) {
+			return fmt.Errorf("FUNCTION_RUNTIME %s is only supported on Linux, unset OS_TYPE or set it to %s", cfg.FunctionRuntime, osTypeLinux)
+		}
+	default:
+		return fmt.Errorf("invalid OS_TYPE %q, accepted values are: %s, %s", cfg.AzureOSType, osTypeLinux, osTypeWindows)
+	}
+	if _, err := lookupAzureCloud(cfg.AzureCloud); err != nil {
+		return fmt.Errorf("invalid AZURE_CLOUD: %w", err)
+	}
+	functions, err := parseFunctions(*cfg)
+	if err != nil {
+		return err
+	}
+	if !cfg.SkipTemplateValidation {
+		for _, fn := range functions {
+			if err := validateFunctionTemplate(cfg.FunctionRuntime, fn.Template); err != nil {
+				return fmt.Errorf("function %s: %w", fn.Name, err)
+			}
+		}
+	}
+	if err := validateCORS(*cfg); err != nil {
+		return err
+	}
+	if err := validateUserAssignedIdentity(*cfg); err != nil {
+		return err
+	}
+	if err := validateAuth(*cfg); err != nil {
+		return err
+	}
+	if _, err := parseAppSettings(*cfg); err != nil {
+		return fmt.Errorf("invalid APP_SETTINGS: %w", err)
+	}
+	if _, err := parseTags(cfg.ResourceTags); err != nil {
+		return fmt.Errorf("invalid RESOURCE_TAGS: %w", err)
+	}
+	if err := validateHostingPlan(cfg.PlanType, cfg.PlanSKU); err != nil {
+		return err
+	}
+	// PUBLISH_MODE=zip is accepted as another name for zipdeploy
+	if cfg.PublishMode == "zip" {
+		cfg.PublishMode = publishModeZipDeploy
+	}
+	if !slices.Contains([]string{publishModeFunc, publishModeZipDeploy, publishModeRunFromPackage}, cfg.PublishMode) {
+		return fmt.Errorf("invalid PUBLISH_MODE %q, accepted values are: %s, %s, %s",
+			cfg.PublishMode, publishModeFunc, publishModeZipDeploy, publishModeRunFromPackage)
+	}
+
+	log.Println("All required environment variables are set.")
+
+	projectDir, err := resolveFunctionProjectDir(cfg.FunctionProjectDir)
+	if err != nil {
+		return fmt.Errorf("invalid FUNCTION_PROJECT_DIR: %w", err)
+	}
+	cfg.FunctionProjectDir = projectDir
+	log.Println("Function App Project Directory:", cfg.FunctionProjectDir)
+	return nil
+}
+
+// resolveFunctionProjectDir returns the absolute project directory, falling back to
+// defaultFunctionProjectDir, and checks that its parent directory is writable
+func resolveFunctionProjectDir(dir string) (string, error) {
+	if dir == "" {
+		dir = defaultFunctionProjectDir
+	}
+
+	dir, err := expandPath(dir)
+	if err != nil {
+		return "", err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path for %q: %v", dir, err)
+	}
+
+	// The project directory may not exist yet, so check the closest existing ancestor
+	// that initializeFunctionProject would create it under
+	parent := filepath.Dir(absDir)
+	for {
+		info, err := os.Stat(parent)
+		if err == nil {
+			if !info.IsDir() {
+				return "", fmt.Errorf("parent path %s is not a directory", parent)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to stat parent directory %s: %v", parent, err)
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			return "", fmt.Errorf("no existing parent directory found for %s", absDir)
+		}
+		parent = next
+	}
+
+	probe, err := os.CreateTemp(parent, ".write-check-*")
+	if err != nil {
+		return "", fmt.Errorf("parent directory %s is not writable: %v", parent, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return absDir, nil
+}
+
+// expandPath expands environment variables and a leading ~ to the user's home directory
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand ~ in %q: %v", path, err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	return path, nil
+}
+
+// runCommand runs a CLI command in dir (the current directory when empty) using
+// cfg.CommandRunner, or a local process runner when it is nil, and returns its
+// combined output
+func runCommand(ctx context.Context, cfg Config, dir, name string, args ...string) ([]byte, error) {
+	runner := cfg.CommandRunner
+	if runner == nil {
+		runner = execCommandRunner{timeout: cfg.CLITimeout, verbose: cfg.Verbose, output: cfg.CommandOutput}
+	}
+	return runner.Run(ctx, commandPath(cfg, name), args, dir)
+}
+
+// commandPath returns the executable to run for the az or func CLI: AZ_PATH or FUNC_PATH
+// when set, otherwise the bare name looked up on PATH
+func commandPath(cfg Config, name string) string {
+	switch {
+	case name == "az" && cfg.AzPath != "":
+		return cfg.AzPath
+	case name == "func" && cfg.FuncPath != "":
+		return cfg.FuncPath
+	}
+	return name
+}
+
+// execCommandRunner runs commands as local child processes using exec.CommandContext.
+// When verbose is set the output is also streamed live, line by line through the logger
+// or raw to output when it is set. The command is killed
+// if it runs longer than timeout, in which case the output captured so far is still
+// returned
+type execCommandRunner struct {
+	timeout time.Duration
+	verbose bool
+	output  io.Writer
+}
+
+// Run implements CommandRunner
+func (r execCommandRunner) Run(ctx context.Context, name string, args []string, dir string) ([]byte, error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	// On cancellation ask the CLI to stop as Ctrl-C would, and kill it only if it has
+	// not exited after cliGracePeriod
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cliGracePeriod
+	cmd.Dir = dir
+	// Pass through the environment (e.g., AZURE_SUBSCRIPTION_ID)
+	cmd.Env = os.Environ()
+
+	// Capture stdout and stderr into one buffer, teeing it to the live output when verbose.
+	// Using the same writer for both makes exec serialize the writes
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if r.verbose {
+		if r.output != nil {
+			w = io.MultiWriter(r.output, &buf)
+		} else {
+			lines := newLineLogger(name)
+			defer lines.Flush()
+			w = io.MultiWriter(lines, &buf)
+		}
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	err := cmd.Run()
+	output := buf.Bytes()
+	if parent.Err() != nil {
+		// The deployment was interrupted or DEPLOY_TIMEOUT expired, not CLI_TIMEOUT
+		return output, fmt.Errorf("%s cancelled: %w", commandVerb(name, args), parent.Err())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return output, fmt.Errorf("%s timed out after %s", commandVerb(name, args), r.timeout)
+	}
+	return output, err
+}
+
+// commandVerb names a CLI command in error messages by its leading arguments, up to the
+// first flag, such as "az functionapp config appsettings set". Flag values such as app
+// settings may hold secrets, so they are left out
+func commandVerb(name string, args []string) string {
+	verb := []string{name}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		verb = append(verb, arg)
+	}
+	return strings.Join(verb, " ")
+}
+
+// logCommandOutput logs the output of a successful CLI command, unless it was already
+// streamed live because VERBOSE is set
+func logCommandOutput(cfg Config, command string, output []byte) {
+	if cfg.Verbose {
+		return
+	}
+	log.Printf("%s output:\n%s\n", command, string(output))
+}
+
+// isCommandAvailable checks if a command is available in the system's PATH, or is an
+// executable file when given as a path
+func isCommandAvailable(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// parseBool parses a boolean setting, accepting the strconv.ParseBool values in any case
+// as well as yes/no, y/n and on/off
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.ToLower(strings.TrimSpace(value)))
+}
+
+// REUSE_RESOURCE_GROUP modes. A reused group is never modified or deleted; a missing one
+// fails the run with reuseGroupRequired and is created with reuseGroupCreate
+const (
+	reuseGroupOff      = ""
+	reuseGroupRequired = "true"
+	reuseGroupCreate   = "create"
+)
+
+// parseReuseResourceGroup maps a REUSE_RESOURCE_GROUP value, a boolean or create, to its
+// mode. When REUSE_RESOURCE_GROUP is unset, USE_EXISTING_RESOURCE_GROUP=true is read as
+// another name for create
+func parseReuseResourceGroup(reuse, useExisting string) (string, error) {
+	reuse = strings.TrimSpace(reuse)
+	switch {
+	case strings.EqualFold(reuse, reuseGroupCreate):
+		return reuseGroupCreate, nil
+	case reuse != "":
+		required, err := parseBool(reuse)
+		if err != nil {
+			return "", fmt.Errorf("invalid REUSE_RESOURCE_GROUP %q, accepted values are: true, false, %s", reuse, reuseGroupCreate)
+		}
+		if required {
+			return reuseGroupRequired, nil
+		}
+		return reuseGroupOff, nil
+	case strings.TrimSpace(useExisting) != "":
+		create, err := parseBool(useExisting)
+		if err != nil {
+			return "", fmt.Errorf("invalid USE_EXISTING_RESOURCE_GROUP %q, accepted values are: true, false", useExisting)
+		}
+		if create {
+			return reuseGroupCreate, nil
+		}
+	}
+	return reuseGroupOff, nil
+}
+
+// createResourceGroup creates an Azure Resource Group, or fetches the existing one
+// without modifying it when REUSE_RESOURCE_GROUP is set
+func createResourceGroup(ctx context.Context, cfg Config) (*armresources.ResourceGroup, error) {
+	if cfg.ReuseResourceGroup != reuseGroupOff {
+		existing, err := getExistingResourceGroup(ctx, cfg)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DryRun {
+		planDryRun("create resource group %s (location=%s, subscription=%s, tags=%s)",
+			cfg.AzureResourceGroupName, cfg.AzureLocation, cfg.AzureSubscriptionID, formatTags(tags))
+		return &armresources.ResourceGroup{
+			ID:       to.Ptr(resourceGroupID(cfg)),
+			Name:     to.Ptr(cfg.AzureResourceGroupName),
+			Location: to.Ptr(cfg.AzureLocation),
+			Tags:     tags,
+		}, nil
+	}
+
+	resourceGroupResp, err := resourceGroupClient.CreateOrUpdate(
+		ctx,
+		cfg.AzureResourceGroupName,
+		armresources.ResourceGroup{
+			Location: to.Ptr(cfg.AzureLocation),
+			Tags:     tags,
+		},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceGroupResp.ResourceGroup, nil
+}
+
+// getExistingResourceGroup fetches the configured Resource Group for REUSE_RESOURCE_GROUP,
+// checking that it is in AZURE_LOCATION. A missing group is an error unless the mode is
+// reuseGroupCreate, in which case nil is returned so that it is created. The lookup is
+// read-only, so it is still performed in dry-run mode
+func getExistingResourceGroup(ctx context.Context, cfg Config) (*armresources.ResourceGroup, error) {
+	resourceGroup, err := lookupResourceGroup(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if resourceGroup == nil {
+		if cfg.ReuseResourceGroup == reuseGroupCreate {
+			log.Println("Resource group does not exist yet, creating it:", cfg.AzureResourceGroupName)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("resource group %s does not exist and REUSE_RESOURCE_GROUP is set, set it to %s to create a missing group",
+			cfg.AzureResourceGroupName, reuseGroupCreate)
+	}
+	if !sameLocation(derefString(resourceGroup.Location), cfg.AzureLocation) {
+		return nil, fmt.Errorf("existing resource group %s is in %s, not AZURE_LOCATION %s",
+			cfg.AzureResourceGroupName, derefString(resourceGroup.Location), cfg.AzureLocation)
+	}
+	log.Println("Reusing existing Resource Group without modifying it:", cfg.AzureResourceGroupName)
+	return resourceGroup, nil
+}
+
+// lookupResourceGroup fetches the configured Resource Group, returning nil if it does
+// not exist. The lookup is read-only, so it is still performed in dry-run mode
+func lookupResourceGroup(ctx context.Context, cfg Config) (*armresources.ResourceGroup, error) {
+	resp, err := resourceGroupClient.Get(ctx, cfg.AzureResourceGroupName, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &resp.ResourceGroup, nil
+}
+
+// checkNameAvailability checks if the storage account name is available. The check is
+// read-only, so it is still performed in dry-run mode to give real feedback
+func checkNameAvailability(ctx context.Context, cfg Config) (*armstorage.CheckNameAvailabilityResult, error) {
+	result, err := accountsClient.CheckNameAvailability(
+		ctx,
+		armstorage.AccountCheckNameAvailabilityParameters{
+			Name: to.Ptr(cfg.AzureStorageAccountName),
+			Type: to.Ptr("Microsoft.Storage/storageAccounts"),
+		},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result.CheckNameAvailabilityResult, nil
+}
 
-func main() {
-	// Step 1: Load environment variables from .env file
-	err := godotenv.Load()
+// findExistingStorageAccount returns the Storage Account if it already exists in the
+// configured Resource Group, or nil if it does not. The lookup is read-only, so it is
+// still performed in dry-run mode
+func findExistingStorageAccount(ctx context.Context, cfg Config) (*armstorage.Account, error) {
+	resp, err := accountsClient.GetProperties(
+		ctx,
+		storageResourceGroup(cfg),
+		cfg.AzureStorageAccountName,
+		nil,
+	)
 	if err != nil {
-		log.Println("Error loading .env file:", err)
-	} else {
-		log.Println(".env file loaded successfully.")
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
 	}
+	return &resp.Account, nil
+}
 
-	// Step 2: Load configuration into Config struct
-	config := loadConfig()
-
-	// Step 3: Validate required environment variables
-	validateConfig(config)
+// checkExistingStorageAccount confirms that the Storage Account required by
+// USE_EXISTING_STORAGE_ACCOUNT was found in its resource group and is
+// usable. When it was not found, the name availability check tells a missing account
+// apart from one in another resource group
+func checkExistingStorageAccount(ctx context.Context, cfg Config, account *armstorage.Account) error {
+	if account == nil {
+		availability, err := withRetry(ctx, cfg, "check storage account name availability", func() (*armstorage.CheckNameAvailabilityResult, error) {
+			return checkNameAvailability(ctx, cfg)
+		})
+		if err != nil {
+			return err
+		}
+		if *availability.NameAvailable {
+			return fmt.Errorf("storage account %s does not exist and USE_EXISTING_STORAGE_ACCOUNT is set", cfg.AzureStorageAccountName)
+		}
+		return fmt.Errorf("storage account %s exists but not in resource group %s, set STORAGE_RESOURCE_GROUP to the group that owns it",
+			cfg.AzureStorageAccountName, storageResourceGroup(cfg))
+	}
 
-	// Step 4: Validate that required commands are available
-	if !isCommandAvailable("az") {
-		log.Fatal("'az' command is not available. Please install Azure CLI.")
+	if account.Properties != nil && account.Properties.ProvisioningState != nil &&
+		*account.Properties.ProvisioningState != armstorage.ProvisioningStateSucceeded {
+		return fmt.Errorf("storage account %s is not usable, its provisioning state is %s",
+			cfg.AzureStorageAccountName, *account.Properties.ProvisioningState)
+	}
+	if account.Kind != nil && *account.Kind != armstorage.KindStorageV2 && *account.Kind != armstorage.KindStorage {
+		return fmt.Errorf("storage account %s is of kind %s, Function Apps need a general-purpose (StorageV2 or Storage) account",
+			cfg.AzureStorageAccountName, *account.Kind)
+	}
+	if account.Location != nil && !sameLocation(*account.Location, cfg.AzureLocation) {
+		log.Printf("Warning: storage account %s is in location %s, not %s as the Function App",
+			cfg.AzureStorageAccountName, *account.Location, cfg.AzureLocation)
 	}
+	log.Println("Storage settings such as SKU, TLS, encryption and network rules are not applied to an existing account")
+	return nil
+}
 
-	if !isCommandAvailable("func") {
-		log.Fatal("'func' command is not available. Please install Azure Functions Core Tools.")
+// sameLocation reports whether two Azure location names refer to the same region,
+// ignoring case and spaces (e.g. "West US" and "westus")
+func sameLocation(a, b string) bool {
+	normalize := func(l string) string {
+		return strings.ToLower(strings.ReplaceAll(l, " ", ""))
 	}
+	return normalize(a) == normalize(b)
+}
 
-	// Step 5: Initialize Azure SDK credentials
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// createStorageAccount creates an Azure Storage Account
+func createStorageAccount(ctx context.Context, cfg Config) (*armstorage.Account, error) {
+	params, err := storageAccountParameters(cfg)
 	if err != nil {
-		log.Fatalf("Failed to obtain a credential: %v", err)
+		return nil, err
 	}
-	ctx := context.Background()
 
-	// Step 6: Initialize Azure SDK clients
-	resourcesClientFactory, err = armresources.NewClientFactory(config.AzureSubscriptionID, cred, nil)
-	if err != nil {
-		log.Fatalf("Failed to create resources client factory: %v", err)
+	if cfg.DryRun {
+		tier := "none"
+		if params.Properties.AccessTier != nil {
+			tier = string(*params.Properties.AccessTier)
+		}
+		planDryRun("create storage account %s (resource group=%s, location=%s, kind=%s, sku=%s, access tier=%s, min TLS=%s, HTTPS only=%t, key source=%s, tags=%s)",
+			cfg.AzureStorageAccountName, cfg.AzureResourceGroupName, cfg.AzureLocation,
+			*params.Kind, *params.SKU.Name, tier, *params.Properties.MinimumTLSVersion, cfg.StorageHTTPSOnly,
+			*params.Properties.Encryption.KeySource, formatTags(params.Tags))
+		return dryRunStorageAccount(cfg), nil
 	}
-	resourceGroupClient = resourcesClientFactory.NewResourceGroupsClient()
 
-	storageClientFactory, err = armstorage.NewClientFactory(config.AzureSubscriptionID, cred, nil)
+	pollerResp, err := accountsClient.BeginCreate(
+		ctx,
+		cfg.AzureResourceGroupName,
+		cfg.AzureStorageAccountName,
+		params,
+		nil,
+	)
 	if err != nil {
-		log.Fatalf("Failed to create storage client factory: %v", err)
+		return nil, withKeyVaultHint(cfg, err)
 	}
-	accountsClient = storageClientFactory.NewAccountsClient()
-
-	// Step 7: Create Resource Group
-	resourceGroup, err := createResourceGroup(ctx, config)
+	resp, err := pollWithProgress(ctx, cfg, StepCreateStorageAccount, pollerResp)
 	if err != nil {
-		log.Fatalf("Failed to create resource group: %v", err)
+		return nil, withKeyVaultHint(cfg, err)
 	}
-	log.Println("Resource Group Created:", *resourceGroup.ID)
+	return &resp.Account, nil
+}
 
-	// Step 8: Check Storage Account Name Availability
-	availability, err := checkNameAvailability(ctx, config)
+// storageAccountParameters builds the Storage Account creation parameters from the
+// configuration. They are also exported as the storage account of the ARM template
+func storageAccountParameters(cfg Config) (armstorage.AccountCreateParameters, error) {
+	skuName, err := parseStorageSKU(cfg.StorageSKU)
 	if err != nil {
-		log.Fatalf("Failed to check storage account name availability: %v", err)
-	}
-	if !*availability.NameAvailable {
-		log.Fatalf("Storage account name is not available: %s", *availability.Message)
+		return armstorage.AccountCreateParameters{}, err
 	}
 
-	// Step 9: Create Storage Account
-	storageAccount, err := createStorageAccount(ctx, config)
+	kind, err := parseStorageKind(cfg.StorageKind)
 	if err != nil {
-		log.Fatalf("Failed to create storage account: %v", err)
+		return armstorage.AccountCreateParameters{}, err
 	}
-	log.Println("Storage Account Created:", *storageAccount.ID)
 
-	// Step 10: Get Storage Account Properties
-	properties, err := storageAccountProperties(ctx, config)
+	accessTier, err := parseAccessTier(cfg.StorageAccessTier)
 	if err != nil {
-		log.Fatalf("Failed to get storage account properties: %v", err)
+		return armstorage.AccountCreateParameters{}, err
+	}
+	// Only standard StorageV2 and BlobStorage accounts have an access tier; Azure rejects
+	// one on the other kinds and on premium SKUs
+	var accessTierParam *armstorage.AccessTier
+	if hasAccessTier(kind, skuName) {
+		accessTierParam = to.Ptr(accessTier)
+		log.Println("Storage Account Access Tier:", accessTier)
+	} else {
+		log.Printf("Ignoring ACCESS_TIER, storage accounts of kind %s with SKU %s have no access tier", kind, skuName)
 	}
-	log.Println("Storage Account Properties ID:", *properties.ID)
 
-	// Step 11: Initialize Function App Project (if not already)
-	err = initializeFunctionProject()
+	minTLSVersion, err := parseMinTLSVersion(cfg.StorageMinTLS)
 	if err != nil {
-		log.Fatalf("Failed to initialize Function App project: %v", err)
+		return armstorage.AccountCreateParameters{}, err
 	}
-	log.Println("Function App Project Initialized Successfully.")
 
-	// Step 12: Create New Function using `func new`
-	err = createNewFunction(config)
+	tags, err := parseTags(cfg.ResourceTags)
 	if err != nil {
-		log.Fatalf("Failed to create new Function: %v", err)
+		return armstorage.AccountCreateParameters{}, err
 	}
-	log.Println("New Function Created Successfully.")
 
-	// Step 13: Execute Azure CLI Command to Create Function App
-	err = createFunctionApp(config)
+	encryption, identity, err := buildEncryption(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create Function App: %v", err)
+		return armstorage.AccountCreateParameters{}, err
 	}
-	log.Println("Function App Created Successfully.")
 
-	// Step 14: Publish Function App
-	err = publishFunctionApp(config)
+	networkRules, err := buildNetworkRuleSet(cfg)
 	if err != nil {
-		log.Fatalf("Failed to publish Function App: %v", err)
+		return armstorage.AccountCreateParameters{}, err
 	}
-	log.Println("Function App Published Successfully.")
 
-	// Step 15: Cleanup Resources if KEEP_RESOURCE is not set
-	if !shouldKeepResource(config.KeepResource) {
-		err = cleanup(ctx, config)
-		if err != nil {
-			log.Fatalf("Failed to clean up resources: %v", err)
-		}
-		log.Println("Resources cleaned up successfully.")
+	params := armstorage.AccountCreateParameters{
+		Kind:     to.Ptr(kind),
+		SKU:      &armstorage.SKU{Name: to.Ptr(skuName)},
+		Location: to.Ptr(cfg.AzureLocation),
+		Tags:     tags,
+		Identity: identity,
+		Properties: &armstorage.AccountPropertiesCreateParameters{
+			AccessTier:        accessTierParam,
+			MinimumTLSVersion: to.Ptr(minTLSVersion),
+			// Containers can only be made public when the account allows it
+			AllowBlobPublicAccess:  to.Ptr(!strings.EqualFold(cfg.BlobContainerPublicAccess, string(armstorage.PublicAccessNone))),
+			EnableHTTPSTrafficOnly: to.Ptr(cfg.StorageHTTPSOnly),
+			Encryption:             encryption,
+			NetworkRuleSet:         networkRules,
+		},
 	}
-}
+	applyStorageFeatures(cfg, kind, params.Properties)
 
-// loadConfig retrieves environment variables and populates the Config struct
-func loadConfig() Config {
-	return Config{
-		AzureSubscriptionID:     os.Getenv("AZURE_SUBSCRIPTION_ID"),
-		AzureLocation:           os.Getenv("AZURE_LOCATION"),
-		AzureResourceGroupName:  os.Getenv("AZURE_RESOURCE_GROUP_NAME"),
-		AzureStorageAccountName: os.Getenv("AZURE_STORAGE_ACCOUNT_NAME"),
-		AzureFunctionAppName:    os.Getenv("AZURE_FUNCTION_APP_NAME"),
-		FunctionName:            os.Getenv("FUNCTION_NAME"),
-		FunctionTemplate:        os.Getenv("FUNCTION_TEMPLATE"),
-		AuthLevel:               os.Getenv("AUTH_LEVEL"),
-		KeepResource:            os.Getenv("KEEP_RESOURCE"),
-	}
-}
-
-// validateConfig checks that all required environment variables are set
-func validateConfig(cfg Config) {
-	missingVars := []string{}
+	return params, nil
+}
 
-	if cfg.AzureSubscriptionID == "" {
-		missingVars = append(missingVars, "AZURE_SUBSCRIPTION_ID")
-	}
-	if cfg.AzureLocation == "" {
-		missingVars = append(missingVars, "AZURE_LOCATION")
-	}
-	if cfg.AzureResourceGroupName == "" {
-		missingVars = append(missingVars, "AZURE_RESOURCE_GROUP_NAME")
-	}
-	if cfg.AzureStorageAccountName == "" {
-		missingVars = append(missingVars, "AZURE_STORAGE_ACCOUNT_NAME")
-	}
-	if cfg.AzureFunctionAppName == "" {
-		missingVars = append(missingVars, "AZURE_FUNCTION_APP_NAME")
+// parseStorageSKU maps a STORAGE_SKU value such as Standard_GRS to its armstorage.SKUName
+func parseStorageSKU(value string) (armstorage.SKUName, error) {
+	accepted := []string{}
+	for _, sku := range armstorage.PossibleSKUNameValues() {
+		if strings.EqualFold(value, string(sku)) {
+			return sku, nil
+		}
+		accepted = append(accepted, string(sku))
 	}
-	if cfg.FunctionName == "" {
-		missingVars = append(missingVars, "FUNCTION_NAME")
+	return "", fmt.Errorf("unknown storage SKU %q, accepted values are: %s", value, strings.Join(accepted, ", "))
+}
+
+// parseStorageKind maps a STORAGE_KIND value such as BlockBlobStorage to its armstorage.Kind
+func parseStorageKind(value string) (armstorage.Kind, error) {
+	accepted := []string{}
+	for _, kind := range armstorage.PossibleKindValues() {
+		if strings.EqualFold(value, string(kind)) {
+			return kind, nil
+		}
+		accepted = append(accepted, string(kind))
 	}
-	if cfg.FunctionTemplate == "" {
-		missingVars = append(missingVars, "FUNCTION_TEMPLATE")
+	return "", fmt.Errorf("unknown storage kind %q, accepted values are: %s", value, strings.Join(accepted, ", "))
+}
+
+// validateStorageKind rejects kind and SKU combinations Azure does not support: the
+// premium FileStorage and BlockBlobStorage kinds need a premium SKU, the legacy
+// BlobStorage kind a standard LRS, GRS or RAGRS SKU, and zone-redundant geo replication
+// a StorageV2 account. Kinds other than StorageV2 and Storage cannot back a Function
+// App, which needs queues and tables, so they only get a warning
+func validateStorageKind(kind armstorage.Kind, sku armstorage.SKUName) error {
+	premium := strings.HasPrefix(string(sku), "Premium_")
+	switch kind {
+	case armstorage.KindFileStorage, armstorage.KindBlockBlobStorage:
+		if !premium {
+			return fmt.Errorf("STORAGE_KIND %s requires a premium STORAGE_SKU (Premium_LRS or Premium_ZRS), got %s", kind, sku)
+		}
+	case armstorage.KindBlobStorage:
+		if !slices.Contains([]armstorage.SKUName{armstorage.SKUNameStandardLRS, armstorage.SKUNameStandardGRS, armstorage.SKUNameStandardRAGRS}, sku) {
+			return fmt.Errorf("STORAGE_KIND %s requires STORAGE_SKU Standard_LRS, Standard_GRS or Standard_RAGRS, got %s", kind, sku)
+		}
+	case armstorage.KindStorage:
+		if sku == armstorage.SKUNameStandardGZRS || sku == armstorage.SKUNameStandardRAGZRS || sku == armstorage.SKUNamePremiumZRS {
+			return fmt.Errorf("STORAGE_KIND %s does not support STORAGE_SKU %s, use StorageV2", kind, sku)
+		}
 	}
-	if cfg.AuthLevel == "" {
-		missingVars = append(missingVars, "AUTH_LEVEL")
+	if kind != armstorage.KindStorageV2 && kind != armstorage.KindStorage {
+		log.Printf("Warning: STORAGE_KIND %s is not a general-purpose account and cannot be used as the Function App's storage", kind)
 	}
+	return nil
+}
 
-	if len(missingVars) > 0 {
-		log.Fatalf("Missing required environment variables: %v", missingVars)
+// applyStoragePremium sets the SKU and kind of a premium block blob account for
+// STORAGE_PREMIUM, warning about a STORAGE_SKU or STORAGE_KIND it overrides
+func applyStoragePremium(cfg *Config) {
+	sku, kind := string(armstorage.SKUNamePremiumLRS), string(armstorage.KindBlockBlobStorage)
+	if value := os.Getenv("STORAGE_SKU"); value != "" && !strings.EqualFold(value, sku) {
+		log.Printf("Warning: STORAGE_PREMIUM is set, using STORAGE_SKU %s instead of %s", sku, value)
 	}
-
-	log.Println("All required environment variables are set.")
+	if value := os.Getenv("STORAGE_KIND"); value != "" && !strings.EqualFold(value, kind) {
+		log.Printf("Warning: STORAGE_PREMIUM is set, using STORAGE_KIND %s instead of %s", kind, value)
+	}
+	cfg.StorageSKU, cfg.StorageKind = sku, kind
 }
 
-// isCommandAvailable checks if a command is available in the system's PATH.
-func isCommandAvailable(name string) bool {
-	_, err := exec.LookPath(name)
-	return err == nil
+// hasAccessTier reports whether storage accounts of the kind and SKU accept an access tier
+func hasAccessTier(kind armstorage.Kind, sku armstorage.SKUName) bool {
+	return (kind == armstorage.KindStorageV2 || kind == armstorage.KindBlobStorage) &&
+		!strings.HasPrefix(string(sku), "Premium_")
 }
 
-// shouldKeepResource determines whether to keep Azure resources based on KEEP_RESOURCE value
-func shouldKeepResource(keep string) bool {
-	switch keep {
-	case "1", "true", "True", "TRUE":
-		return true
-	default:
-		return false
+// parseAccessTier maps an ACCESS_TIER value of Hot or Cool to its armstorage.AccessTier
+func parseAccessTier(value string) (armstorage.AccessTier, error) {
+	for _, tier := range []armstorage.AccessTier{armstorage.AccessTierHot, armstorage.AccessTierCool} {
+		if strings.EqualFold(value, string(tier)) {
+			return tier, nil
+		}
 	}
+	return "", fmt.Errorf("unknown access tier %q, accepted values are: Hot, Cool", value)
 }
 
-// createResourceGroup creates an Azure Resource Group
-func createResourceGroup(ctx context.Context, cfg Config) (*armresources.ResourceGroup, error) {
-	resourceGroupResp, err := resourceGroupClient.CreateOrUpdate(
-		ctx,
-		cfg.AzureResourceGroupName,
-		armresources.ResourceGroup{
-			Location: to.Ptr(cfg.AzureLocation),
-		},
-		nil,
-	)
+// configureBlobDataProtection sets blob soft delete retention and versioning on the
+// Storage Account's blob service
+func configureBlobDataProtection(ctx context.Context, cfg Config) (*armstorage.BlobServiceProperties, error) {
+	params := blobServiceParameters(cfg)
+
+	if cfg.DryRun {
+		planDryRun("configure blob service of storage account %s (soft delete days=%d, versioning=%t)",
+			cfg.AzureStorageAccountName, cfg.BlobSoftDeleteDays, cfg.BlobVersioning)
+		return &params, nil
+	}
+
+	resp, err := blobServicesClient.SetServiceProperties(ctx, cfg.AzureResourceGroupName, cfg.AzureStorageAccountName, params, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to set blob service properties: %w", err)
 	}
-	return &resourceGroupResp.ResourceGroup, nil
+	log.Printf("Blob data protection configured: soft delete days=%d, versioning=%t", cfg.BlobSoftDeleteDays, cfg.BlobVersioning)
+	return &resp.BlobServiceProperties, nil
 }
 
-// checkNameAvailability checks if the storage account name is available
-func checkNameAvailability(ctx context.Context, cfg Config) (*armstorage.CheckNameAvailabilityResult, error) {
-	result, err := accountsClient.CheckNameAvailability(
-		ctx,
-		armstorage.AccountCheckNameAvailabilityParameters{
-			Name: to.Ptr(cfg.AzureStorageAccountName),
-			Type: to.Ptr("Microsoft.Storage/storageAccounts"),
+// blobServiceParameters builds the blob service properties for BLOB_SOFT_DELETE_DAYS and
+// BLOB_VERSIONING
+func blobServiceParameters(cfg Config) armstorage.BlobServiceProperties {
+	retention := &armstorage.DeleteRetentionPolicy{Enabled: to.Ptr(cfg.BlobSoftDeleteDays > 0)}
+	if cfg.BlobSoftDeleteDays > 0 {
+		retention.Days = to.Ptr(int32(cfg.BlobSoftDeleteDays))
+	}
+	return armstorage.BlobServiceProperties{
+		BlobServiceProperties: &armstorage.BlobServicePropertiesProperties{
+			DeleteRetentionPolicy: retention,
+			IsVersioningEnabled:   to.Ptr(cfg.BlobVersioning),
 		},
-		nil,
-	)
-	if err != nil {
-		return nil, err
 	}
-	return &result.CheckNameAvailabilityResult, nil
 }
 
-// createStorageAccount creates an Azure Storage Account
-func createStorageAccount(ctx context.Context, cfg Config) (*armstorage.Account, error) {
-	pollerResp, err := accountsClient.BeginCreate(
-		ctx,
-		cfg.AzureResourceGroupName,
-		cfg.AzureStorageAccountName,
-		armstorage.AccountCreateParameters{
-			Kind:     to.Ptr(armstorage.KindStorageV2),
-			SKU:      &armstorage.SKU{Name: to.Ptr(armstorage.SKUNameStandardLRS)},
-			Location: to.Ptr(cfg.AzureLocation),
-			Properties: &armstorage.AccountPropertiesCreateParameters{
-				AccessTier: to.Ptr(armstorage.AccessTierCool),
-				Encryption: &armstorage.Encryption{
-					Services: &armstorage.EncryptionServices{
-						File:  &armstorage.EncryptionService{KeyType: to.Ptr(armstorage.KeyTypeAccount), Enabled: to.Ptr(true)},
-						Blob:  &armstorage.EncryptionService{KeyType: to.Ptr(armstorage.KeyTypeAccount), Enabled: to.Ptr(true)},
-						Queue: &armstorage.EncryptionService{KeyType: to.Ptr(armstorage.KeyTypeAccount), Enabled: to.Ptr(true)},
-						Table: &armstorage.EncryptionService{KeyType: to.Ptr(armstorage.KeyTypeAccount), Enabled: to.Ptr(true)},
-					},
-					KeySource: to.Ptr(armstorage.KeySourceMicrosoftStorage),
-				},
-			},
-		}, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := pollerResp.PollUntilDone(ctx, nil)
-	if err != nil {
-		return nil, err
+// supportedMinTLSVersions lists the accepted MIN_TLS_VERSION values
+var supportedMinTLSVersions = []armstorage.MinimumTLSVersion{
+	armstorage.MinimumTLSVersionTLS10,
+	armstorage.MinimumTLSVersionTLS11,
+	armstorage.MinimumTLSVersionTLS12,
+}
+
+// parseMinTLSVersion maps a MIN_TLS_VERSION value such as TLS1_2 to its armstorage.MinimumTLSVersion
+func parseMinTLSVersion(value string) (armstorage.MinimumTLSVersion, error) {
+	accepted := []string{}
+	for _, version := range supportedMinTLSVersions {
+		if strings.EqualFold(value, string(version)) {
+			return version, nil
+		}
+		accepted = append(accepted, string(version))
 	}
-	return &resp.Account, nil
+	return "", fmt.Errorf("unknown minimum TLS version %q, accepted values are: %s", value, strings.Join(accepted, ", "))
 }
 
 // storageAccountProperties retrieves properties of the Storage Account
 func storageAccountProperties(ctx context.Context, cfg Config) (*armstorage.Account, error) {
+	if cfg.DryRun {
+		log.Printf("Skipping property lookup for storage account %s", cfg.AzureStorageAccountName)
+		return dryRunStorageAccount(cfg), nil
+	}
+
 	storageAccountResponse, err := accountsClient.GetProperties(
 		ctx,
-		cfg.AzureResourceGroupName,
+		storageResourceGroup(cfg),
 		cfg.AzureStorageAccountName,
 		nil,
 	)
@@ -296,133 +2013,450 @@ func storageAccountProperties(ctx context.Context, cfg Config) (*armstorage.Acco
 	return &storageAccountResponse.Account, nil
 }
 
-// initializeFunctionProject initializes a new Azure Functions project if not already initialized
-func initializeFunctionProject() error {
+// initializeFunctionProject initializes a new Azure Functions project if not already
+// initialized. A directory containing host.json is treated as an existing project
+func initializeFunctionProject(ctx context.Context, cfg Config) error {
+	if _, err := os.Stat(filepath.Join(cfg.FunctionProjectDir, "host.json")); err == nil {
+		log.Println("Skipping func init: host.json already exists in", cfg.FunctionProjectDir)
+		if cfg.FuncProgrammingModel != "" || cfg.FuncLanguage != "" {
+			log.Println("Warning: FUNC_PROGRAMMING_MODEL and FUNC_LANGUAGE only apply to new projects and are ignored")
+		}
+		checkProjectWorkerRuntime(cfg)
+		return nil
+	}
+
+	if cfg.DryRun {
+		planDryRun("initialize Function App project in %s (func %s)", cfg.FunctionProjectDir, strings.Join(funcInitArgs(cfg), " "))
+		return nil
+	}
+
 	// Check if the project directory exists
-	if _, err := os.Stat(functionProjectDir); os.IsNotExist(err) {
+	if _, err := os.Stat(cfg.FunctionProjectDir); os.IsNotExist(err) {
 		// Create the project directory
-		err := os.MkdirAll(functionProjectDir, os.ModePerm)
+		err := os.MkdirAll(cfg.FunctionProjectDir, os.ModePerm)
 		if err != nil {
 			return fmt.Errorf("failed to create project directory: %v", err)
 		}
 	}
 
-	// Change to the project directory
-	err := os.Chdir(functionProjectDir)
+	// Initialize a new Functions project with the configured runtime, programming model
+	// and language. This step is optional if your project is already initialized
+	output, err := runCommand(ctx, cfg, cfg.FunctionProjectDir, "func", funcInitArgs(cfg)...)
 	if err != nil {
-		return fmt.Errorf("failed to change directory to project directory: %v", err)
+		return fmt.Errorf("func init failed: %v\nOutput: %s", err, outputTail(output))
 	}
 
-	// Initialize a new Functions project with Node.js runtime
-	// This step is optional if your project is already initialized
-	cmd := exec.Command("func", "init", "--worker-runtime", "node")
-	cmd.Env = os.Environ()
-	output, err := cmd.CombinedOutput()
+	logCommandOutput(cfg, "func init", output)
+	return nil
+}
+
+// checkProjectWorkerRuntime warns when an existing project's local.settings.json targets a
+// different worker runtime than FUNCTION_RUNTIME
+func checkProjectWorkerRuntime(cfg Config) {
+	data, err := os.ReadFile(filepath.Join(cfg.FunctionProjectDir, "local.settings.json"))
 	if err != nil {
-		return fmt.Errorf("func init failed: %v\nOutput: %s", err, string(output))
+		return
+	}
+	var settings struct {
+		Values map[string]string `json:"Values"`
 	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return
+	}
+	// dotnet projects may use the dotnet-isolated worker
+	worker := settings.Values["FUNCTIONS_WORKER_RUNTIME"]
+	if worker != "" && !strings.HasPrefix(worker, cfg.FunctionRuntime) {
+		log.Printf("Warning: existing project uses worker runtime %s but FUNCTION_RUNTIME is %s", worker, cfg.FunctionRuntime)
+	}
+}
 
-	log.Printf("func init output:\n%s\n", string(output))
-	return nil
+// functionAppSite holds the fields read from the JSON printed by `az functionapp create`
+// and `az functionapp show`
+type functionAppSite struct {
+	DefaultHostName string `json:"defaultHostName"`
+	Kind            string `json:"kind"`
+	Identity        *struct {
+		PrincipalID string `json:"principalId"`
+	} `json:"identity"`
 }
 
-// createNewFunction creates a new Azure Function using `func new`
-func createNewFunction(cfg Config) error {
-	// Ensure we are in the project directory
-	currentDir, err := os.Getwd()
+// osType returns the operating system of the site from its kind, such as
+// functionapp,linux, or "" when az reported no kind
+func (s functionAppSite) osType() string {
+	switch {
+	case s.Kind == "":
+		return ""
+	case strings.Contains(strings.ToLower(s.Kind), osTypeLinux):
+		return osTypeLinux
+	default:
+		return osTypeWindows
+	}
+}
+
+// showFunctionApp fetches an existing Function App using `az functionapp show`. The
+// lookup is read-only, so it also runs in dry-run mode
+func showFunctionApp(ctx context.Context, cfg Config) (functionAppSite, error) {
+	output, err := runCommand(ctx, cfg, "", "az", "functionapp", "show",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.AzureFunctionAppName,
+		"--output", "json")
 	if err != nil {
-		return fmt.Errorf("failed to get current directory: %v", err)
+		return functionAppSite{}, fmt.Errorf("az functionapp show failed for Function App %s in resource group %s: %v\nOutput: %s",
+			cfg.AzureFunctionAppName, cfg.AzureResourceGroupName, err, outputTail(output))
+	}
+
+	var site functionAppSite
+	if err := json.Unmarshal(output, &site); err != nil {
+		return functionAppSite{}, fmt.Errorf("failed to parse az functionapp show output: %v", err)
 	}
-	log.Println("Current Directory:", currentDir)
+	return site, nil
+}
+
+// functionAppStorageAccount returns the --storage-account argument of `az functionapp
+// create`: the account name, or its resource ID when it is in another resource group
+func functionAppStorageAccount(cfg Config) string {
+	if !strings.EqualFold(storageResourceGroup(cfg), cfg.AzureResourceGroupName) {
+		return storageAccountID(cfg)
+	}
+	return cfg.AzureStorageAccountName
+}
 
-	// Define the arguments for `func new`
+// createFunctionApp creates an Azure Function App using `az functionapp create` and
+// returns the created site
+func createFunctionApp(ctx context.Context, cfg Config) (functionAppSite, error) {
 	cmdArgs := []string{
-		"new",
-		"--name", cfg.FunctionName,
-		"--template", cfg.FunctionTemplate,
-		"--authlevel", cfg.AuthLevel,
+		"functionapp", "create",
+		"--resource-group", cfg.AzureResourceGroupName,
+	}
+	if cfg.PlanType == planTypeConsumption {
+		cmdArgs = append(cmdArgs, "--consumption-plan-location", cfg.AzureLocation)
+	} else {
+		cmdArgs = append(cmdArgs, "--plan", cfg.PlanName)
 	}
+	// A custom container brings its own runtime
+	if cfg.ContainerImage != "" {
+		cmdArgs = append(cmdArgs, containerArgs(cfg)...)
+	} else {
+		cmdArgs = append(cmdArgs, "--runtime", cfg.FunctionRuntime, "--runtime-version", cfg.FunctionRuntimeVersion)
+	}
+	cmdArgs = append(cmdArgs,
+		"--functions-version", cfg.FunctionsVersion,
+		"--name", cfg.AzureFunctionAppName,
+		"--storage-account", functionAppStorageAccount(cfg),
+	)
+	// Always explicit, so the dry-run plan shows the OS the default resolved to
+	cmdArgs = append(cmdArgs, "--os-type", functionAppOS(cfg))
+	// Connect the app to the component created earlier; az sets the connection string
+	// app setting from it
+	if cfg.EnableAppInsights {
+		cmdArgs = append(cmdArgs, "--app-insights", cfg.AppInsightsName)
+	}
+	if identities := identitiesToAssign(cfg); len(identities) > 0 {
+		cmdArgs = append(cmdArgs, "--assign-identity")
+		cmdArgs = append(cmdArgs, identities...)
+	}
+	cmdArgs = append(cmdArgs, "--output", "json")
 
-	cmd := exec.Command("func", cmdArgs...)
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return functionAppSite{}, err
+	}
+	if len(tags) > 0 {
+		cmdArgs = append(cmdArgs, "--tags")
+		cmdArgs = append(cmdArgs, tagArgs(tags)...)
+	}
 
-	// Set environment variables if needed
-	cmd.Env = os.Environ()
+	if cfg.DryRun {
+		planDryRun("create Function App %s (az %s)", cfg.AzureFunctionAppName, strings.Join(maskRegistryPassword(cmdArgs), " "))
+		return functionAppSite{}, nil
+	}
 
-	// Capture standard output and error
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
 	if err != nil {
-		return fmt.Errorf("func new failed: %v\nOutput: %s", err, string(output))
+		return functionAppSite{}, fmt.Errorf("az functionapp create failed: %v\nOutput: %s", err, outputTail(output))
 	}
 
-	log.Printf("func new output:\n%s\n", string(output))
-	return nil
+	logCommandOutput(cfg, "az functionapp create", output)
+
+	// The host name and principal ID are only informational, so a parse failure is not
+	// fatal
+	var site functionAppSite
+	if err := json.Unmarshal(output, &site); err != nil {
+		log.Printf("Warning: could not read the Function App details from the az output: %v", err)
+	}
+	if cfg.AssignIdentity {
+		if site.Identity != nil && site.Identity.PrincipalID != "" {
+			log.Println("Function App system-assigned identity principal ID:", site.Identity.PrincipalID)
+		} else {
+			log.Println("Warning: the az output did not include the Function App identity principal ID")
+		}
+	}
+	return site, nil
 }
 
-// createFunctionApp creates an Azure Function App using `az functionapp create`
-func createFunctionApp(cfg Config) error {
+// functionAppExists reports whether the Function App already exists in its resource
+// group. The check is read-only, so it also runs in dry-run mode
+func functionAppExists(ctx context.Context, cfg Config) (bool, error) {
+	resp, err := resourcesClient.CheckExistenceByID(ctx, functionAppID(cfg), armWebAPIVersion, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether Function App %s exists: %w", cfg.AzureFunctionAppName, err)
+	}
+	return resp.Success, nil
+}
+
+// updateFunctionApp prepares an existing Function App for a redeploy instead of
+// creating it. It reads the app, warns when its OS differs from OS_TYPE, with
+// UPDATE_RUNTIME_VERSION sets FUNCTION_RUNTIME_VERSION on it and links it to
+// Application Insights. App settings, CORS and identities are applied by the later
+// steps as for a new app
+func updateFunctionApp(ctx context.Context, cfg Config) (functionAppSite, error) {
+	site, err := showFunctionApp(ctx, cfg)
+	if err != nil {
+		return functionAppSite{}, err
+	}
+	if current := site.osType(); current != "" && current != functionAppOS(cfg) {
+		log.Printf("Warning: Function App %s runs on %s, not %s, it is not recreated", cfg.AzureFunctionAppName, current, functionAppOS(cfg))
+	}
+	if cfg.ContainerImage != "" {
+		log.Printf("Warning: CONTAINER_IMAGE is not applied to the existing Function App %s", cfg.AzureFunctionAppName)
+	} else if cfg.UpdateRuntimeVersion {
+		if err := updateRuntimeVersion(ctx, cfg); err != nil {
+			return functionAppSite{}, err
+		}
+	}
+	if cfg.EnableAppInsights {
+		if err := linkAppInsights(ctx, cfg); err != nil {
+			return functionAppSite{}, err
+		}
+	}
+	return site, nil
+}
+
+// updateRuntimeVersion sets FUNCTION_RUNTIME_VERSION on an existing Function App with
+// `az functionapp config set`. Node on Windows reads its version from the
+// WEBSITE_NODE_DEFAULT_VERSION app setting instead
+func updateRuntimeVersion(ctx context.Context, cfg Config) error {
+	if functionAppOS(cfg) == osTypeWindows && cfg.FunctionRuntime == "node" {
+		return configureAppSettings(ctx, cfg, map[string]string{"WEBSITE_NODE_DEFAULT_VERSION": "~" + cfg.FunctionRuntimeVersion})
+	}
+
 	cmdArgs := []string{
-		"functionapp", "create",
+		"functionapp", "config", "set",
 		"--resource-group", cfg.AzureResourceGroupName,
-		"--consumption-plan-location", cfg.AzureLocation,
-		"--runtime", "node",
-		"--runtime-version", "18",
-		"--functions-version", "4",
 		"--name", cfg.AzureFunctionAppName,
-		"--storage-account", cfg.AzureStorageAccountName,
 	}
+	switch {
+	case functionAppOS(cfg) == osTypeLinux:
+		cmdArgs = append(cmdArgs, "--linux-fx-version", desiredLinuxFxVersion(cfg))
+	case cfg.FunctionRuntime == "dotnet":
+		cmdArgs = append(cmdArgs, "--net-framework-version", "v"+cfg.FunctionRuntimeVersion)
+	case cfg.FunctionRuntime == "java":
+		cmdArgs = append(cmdArgs, "--java-version", cfg.FunctionRuntimeVersion)
+	case cfg.FunctionRuntime == "powershell":
+		cmdArgs = append(cmdArgs, "--powershell-version", cfg.FunctionRuntimeVersion)
+	}
+	cmdArgs = append(cmdArgs, "--output", "none")
 
-	cmd := exec.Command("az", cmdArgs...)
+	if cfg.DryRun {
+		planDryRun("set runtime version %s %s on Function App %s (az %s)",
+			cfg.FunctionRuntime, cfg.FunctionRuntimeVersion, cfg.AzureFunctionAppName, strings.Join(cmdArgs, " "))
+		return nil
+	}
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
+	if err != nil {
+		return fmt.Errorf("az functionapp config set failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	log.Printf("Runtime version of Function App %s set to %s %s", cfg.AzureFunctionAppName, cfg.FunctionRuntime, cfg.FunctionRuntimeVersion)
+	return nil
+}
 
-	// Set environment variables if needed (e.g., AZURE_SUBSCRIPTION_ID)
-	cmd.Env = os.Environ()
+// functionAppOS returns the operating system of the Function App: OS_TYPE, or when it
+// is unset the platform default, Linux for custom containers and Linux-only runtimes
+// and Windows otherwise
+func functionAppOS(cfg Config) string {
+	if cfg.AzureOSType != "" {
+		return cfg.AzureOSType
+	}
+	if cfg.ContainerImage != "" || slices.Contains(linuxOnlyRuntimes, cfg.FunctionRuntime) {
+		return osTypeLinux
+	}
+	return osTypeWindows
+}
+
+// createHostingPlan creates the Premium (Elastic Premium) or Dedicated (App Service)
+// plan the Function App runs on using `az functionapp plan create`
+func createHostingPlan(ctx context.Context, cfg Config) error {
+	cmdArgs := []string{
+		"functionapp", "plan", "create",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.PlanName,
+		"--location", cfg.AzureLocation,
+		"--sku", cfg.PlanSKU,
+	}
+	if functionAppOS(cfg) == osTypeLinux {
+		cmdArgs = append(cmdArgs, "--is-linux")
+	}
+
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		cmdArgs = append(cmdArgs, "--tags")
+		cmdArgs = append(cmdArgs, tagArgs(tags)...)
+	}
 
-	// Capture standard output and error
-	output, err := cmd.CombinedOutput()
+	if cfg.DryRun {
+		planDryRun("create %s hosting plan %s (az %s)", cfg.PlanType, cfg.PlanName, strings.Join(cmdArgs, " "))
+		return nil
+	}
+
+	output, err := runCommand(ctx, cfg, "", "az", cmdArgs...)
 	if err != nil {
-		return fmt.Errorf("az functionapp create failed: %v\nOutput: %s", err, string(output))
+		return fmt.Errorf("az functionapp plan create failed: %v\nOutput: %s", err, outputTail(output))
 	}
 
-	log.Printf("az functionapp create output:\n%s\n", string(output))
+	logCommandOutput(cfg, "az functionapp plan create", output)
+	return nil
+}
+
+// premiumPlanSKUs are the Elastic Premium SKUs accepted for the premium plan
+var premiumPlanSKUs = []string{"EP1", "EP2", "EP3"}
+
+// validateHostingPlan checks PLAN_TYPE and that PLAN_SKU fits it: consumption takes no
+// SKU, premium needs an Elastic Premium SKU and dedicated an App Service SKU
+func validateHostingPlan(planType, sku string) error {
+	switch planType {
+	case planTypeConsumption:
+		if sku != "" {
+			return fmt.Errorf("PLAN_SKU %s cannot be used with PLAN_TYPE %s, which has no SKU", sku, planType)
+		}
+	case planTypePremium:
+		if !slices.Contains(premiumPlanSKUs, sku) {
+			return fmt.Errorf("PLAN_TYPE %s requires PLAN_SKU to be one of: %s",
+				planType, strings.Join(premiumPlanSKUs, ", "))
+		}
+	case planTypeDedicated:
+		if sku == "" {
+			return fmt.Errorf("PLAN_SKU is required when PLAN_TYPE is %s (e.g. B1, S1 or P1V2)", planType)
+		}
+		if sku == "Y1" || strings.HasPrefix(sku, "EP") {
+			return fmt.Errorf("PLAN_SKU %s is not an App Service SKU, use PLAN_TYPE %s or %s for it",
+				sku, planTypeConsumption, planTypePremium)
+		}
+	default:
+		return fmt.Errorf("invalid PLAN_TYPE %q, accepted values are: %s, %s, %s",
+			planType, planTypeConsumption, planTypePremium, planTypeDedicated)
+	}
 	return nil
 }
 
 // publishFunctionApp publishes the Function App using `func azure functionapp publish`
-func publishFunctionApp(cfg Config) error {
-	// Ensure you are in the Function App project directory
-	err := os.Chdir(functionProjectDir)
-	if err != nil {
-		return fmt.Errorf("failed to change directory to project directory: %v", err)
+func publishFunctionApp(ctx context.Context, cfg Config) error {
+	if cfg.DryRun {
+		planDryRun("publish %s to Function App %s (func azure functionapp publish)",
+			cfg.FunctionProjectDir, cfg.AzureFunctionAppName)
+		return nil
 	}
 
 	cmdArgs := []string{
 		"azure", "functionapp", "publish", cfg.AzureFunctionAppName,
 	}
 
-	cmd := exec.Command("func", cmdArgs...)
-
-	// Set environment variables if needed
-	cmd.Env = os.Environ()
-
-	// Capture standard output and error
-	output, err := cmd.CombinedOutput()
+	// Run inside the Function App project directory without changing the process working directory
+	output, err := runCommand(ctx, cfg, cfg.FunctionProjectDir, "func", cmdArgs...)
 	if err != nil {
-		return fmt.Errorf("func azure functionapp publish failed: %v\nOutput: %s", err, string(output))
+		return fmt.Errorf("func azure functionapp publish failed: %v\nOutput: %s", err, outputTail(output))
 	}
 
-	log.Printf("func azure functionapp publish output:\n%s\n", string(output))
+	logCommandOutput(cfg, "func azure functionapp publish", output)
 	return nil
 }
 
 // cleanup deletes the Resource Group to clean up resources
 func cleanup(ctx context.Context, cfg Config) error {
-	pollerResp, err := resourceGroupClient.BeginDelete(ctx, cfg.AzureResourceGroupName, nil)
-	if err != nil {
-		return err
+	if cfg.DryRun {
+		planDryRun("delete resource group %s and all resources in it", cfg.AzureResourceGroupName)
+		if cfg.EnableAppInsights {
+			planDryRun("delete Application Insights component %s with resource group %s", cfg.AppInsightsName, cfg.AzureResourceGroupName)
+		}
+		return nil
 	}
 
-	_, err = pollerResp.PollUntilDone(ctx, nil)
-	if err != nil {
-		return err
+	return deleteResourceGroup(ctx, cfg)
+}
+
+// parseTags parses RESOURCE_TAGS in key1=value1,key2=value2 format. Whitespace around
+// keys and values is trimmed, empty entries are skipped, empty values are allowed and
+// duplicate keys are rejected
+func parseTags(value string) (map[string]*string, error) {
+	tags := map[string]*string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, found := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !found {
+			return nil, fmt.Errorf("malformed tag %q, expected key=value", entry)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("malformed tag %q, tag key must not be empty", entry)
+		}
+		if strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("malformed tag %q, tag key must not contain whitespace", entry)
+		}
+		if _, exists := tags[key]; exists {
+			return nil, fmt.Errorf("duplicate tag key %q", key)
+		}
+		tags[key] = to.Ptr(strings.TrimSpace(val))
+	}
+	return tags, nil
+}
+
+// tagArgs renders tags as key=value pairs sorted by key, the format accepted by the
+// az CLI --tags argument
+func tagArgs(tags map[string]*string) []string {
+	pairs := []string{}
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, key+"="+*tags[key])
+	}
+	return pairs
+}
+
+// formatTags renders tags for log output
+func formatTags(tags map[string]*string) string {
+	return strings.Join(tagArgs(tags), ",")
+}
+
+// resourceGroupID builds the Azure resource ID of the configured Resource Group
+func resourceGroupID(cfg Config) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", cfg.AzureSubscriptionID, cfg.AzureResourceGroupName)
+}
+
+// dryRunStorageAccount synthesizes the Storage Account that would have been created
+func dryRunStorageAccount(cfg Config) *armstorage.Account {
+	return &armstorage.Account{
+		ID:       to.Ptr(storageAccountID(cfg)),
+		Name:     to.Ptr(cfg.AzureStorageAccountName),
+		Location: to.Ptr(cfg.AzureLocation),
+	}
+}
+
+// planDryRun logs an action skipped in dry-run mode and records it for the final summary
+func planDryRun(format string, args ...any) {
+	action := fmt.Sprintf(format, args...)
+	log.Println("Would", action)
+	dryRunPlanMu.Lock()
+	dryRunPlan = append(dryRunPlan, action)
+	dryRunPlanMu.Unlock()
+}
+
+// logDryRunSummary logs every action that would have been performed
+func logDryRunSummary() {
+	log.Println("Summary of actions that would have been performed:")
+	for i, action := range dryRunPlan {
+		log.Printf("  %d. %s", i+1, action)
 	}
-	return nil
 }
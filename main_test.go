@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandVerb(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"functionapp", "config", "appsettings", "set", "--name", "app", "--settings", "DB_PASSWORD=hunter2"}, "az functionapp config appsettings set"},
+		{[]string{"functionapp", "create", "--tags", "token=hunter2"}, "az functionapp create"},
+		{[]string{"--version"}, "az"},
+	}
+	for _, tt := range tests {
+		got := commandVerb("az", tt.args)
+		if got != tt.want {
+			t.Errorf("commandVerb(az, %v) = %q, want %q", tt.args, got, tt.want)
+		}
+		if strings.Contains(got, "hunter2") {
+			t.Errorf("commandVerb(az, %v) = %q leaks a secret", tt.args, got)
+		}
+	}
+}
+
+func TestLoadConfigIfAppExistsDefault(t *testing.T) {
+	t.Setenv("IF_APP_EXISTS", "")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.IfAppExists != appExistsFail {
+		t.Errorf("IfAppExists = %q, want %q so an existing Function App stops the run", cfg.IfAppExists, appExistsFail)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// storageAccountNamePattern matches 3-24 lowercase letters and digits
+	storageAccountNamePattern = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+	// functionAppNamePattern matches 2-60 letters, digits and hyphens, starting and
+	// ending with a letter or digit
+	functionAppNamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,58}[A-Za-z0-9])?$`)
+	// resourceGroupNamePattern matches 1-90 letters, digits, underscores, parentheses,
+	// hyphens and periods, not ending with a period
+	resourceGroupNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_().-]{0,89}[\p{L}\p{N}_()-]$`)
+)
+
+// validateNames checks the Storage Account, Function App and resource group names
+// against the Azure naming rules, so that an invalid name fails before any network call
+// with the specific rules it breaks
+func validateNames(cfg Config) error {
+	problems := []string{}
+	if !storageAccountNamePattern.MatchString(cfg.AzureStorageAccountName) {
+		problem := fmt.Sprintf("AZURE_STORAGE_ACCOUNT_NAME %q %s", cfg.AzureStorageAccountName,
+			strings.Join(storageAccountNameViolations(cfg.AzureStorageAccountName), ", "))
+		if suggestion := suggestStorageAccountName(cfg.AzureStorageAccountName); suggestion != "" {
+			problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	if !functionAppNamePattern.MatchString(cfg.AzureFunctionAppName) {
+		problems = append(problems, fmt.Sprintf("AZURE_FUNCTION_APP_NAME %q %s", cfg.AzureFunctionAppName,
+			strings.Join(functionAppNameViolations(cfg.AzureFunctionAppName), ", ")))
+	}
+	if !resourceGroupNamePattern.MatchString(cfg.AzureResourceGroupName) {
+		problems = append(problems, fmt.Sprintf("AZURE_RESOURCE_GROUP_NAME %q must be 1-90 letters, digits, underscores, parentheses, hyphens and periods, not ending with a period",
+			cfg.AzureResourceGroupName))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid resource names: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// storageAccountNameViolations lists the Storage Account naming rules that name breaks
+func storageAccountNameViolations(name string) []string {
+	violations := []string{}
+	if n := len(name); n < 3 || n > 24 {
+		violations = append(violations, fmt.Sprintf("is %d characters long, must be 3-24", n))
+	}
+	if strings.ToLower(name) != name {
+		violations = append(violations, "contains uppercase letters")
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !unicode.IsUpper(r) && !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	}) >= 0 {
+		violations = append(violations, "contains characters other than lowercase letters and digits")
+	}
+	return violations
+}
+
+// functionAppNameViolations lists the Function App naming rules that name breaks
+func functionAppNameViolations(name string) []string {
+	violations := []string{}
+	if n := len(name); n < 2 || n > 60 {
+		violations = append(violations, fmt.Sprintf("is %d characters long, must be 2-60", n))
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-'
+	}) >= 0 {
+		violations = append(violations, "contains characters other than letters, digits and hyphens")
+	}
+	if strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		violations = append(violations, "starts or ends with a hyphen")
+	}
+	return violations
+}
+
+// suggestStorageAccountName derives a valid Storage Account name from name by
+// lowercasing it, dropping other characters and truncating it to 24 characters. It
+// returns "" when too little of the name is left
+func suggestStorageAccountName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	suggestion := b.String()
+	if len(suggestion) > 24 {
+		suggestion = suggestion[:24]
+	}
+	if len(suggestion) < 3 {
+		return ""
+	}
+	return suggestion
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// parseDefaultAction maps a NETWORK_DEFAULT_ACTION value of Allow or Deny to its
+// armstorage.DefaultAction
+func parseDefaultAction(value string) (armstorage.DefaultAction, error) {
+	for _, action := range armstorage.PossibleDefaultActionValues() {
+		if strings.EqualFold(value, string(action)) {
+			return action, nil
+		}
+	}
+	return "", fmt.Errorf("unknown default action %q, accepted values are: Allow, Deny", value)
+}
+
+// parseIPRanges parses the comma-separated ALLOWED_IP_RANGES list of IPv4 CIDR ranges or
+// single addresses. Storage rejects /31 and /32 prefixes, so they are converted to the
+// plain address
+func parseIPRanges(value string) ([]string, error) {
+	ranges := []string{}
+	for _, entry := range splitList(value) {
+		if addr, err := netip.ParseAddr(entry); err == nil && addr.Is4() {
+			ranges = append(ranges, addr.String())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil || !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("malformed IP range %q, expected an IPv4 CIDR range such as 203.0.113.0/24 or a single address", entry)
+		}
+		if prefix.Bits() >= 31 {
+			ranges = append(ranges, prefix.Addr().String())
+			continue
+		}
+		ranges = append(ranges, prefix.Masked().String())
+	}
+	return ranges, nil
+}
+
+// splitList splits a comma-separated setting, trimming whitespace and skipping empty entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateNetworkRules checks the storage account network settings and warns when the
+// account will be reachable from any network
+func validateNetworkRules(cfg Config) error {
+	action, err := parseDefaultAction(cfg.NetworkDefaultAction)
+	if err != nil {
+		return fmt.Errorf("invalid NETWORK_DEFAULT_ACTION: %w", err)
+	}
+	ranges, err := parseIPRanges(cfg.AllowedIPRanges)
+	if err != nil {
+		return fmt.Errorf("invalid ALLOWED_IP_RANGES: %w", err)
+	}
+	subnets := splitList(cfg.AllowedSubnetIDs)
+	for _, id := range subnets {
+		if err := validateSubnetID(id); err != nil {
+			return fmt.Errorf("invalid ALLOWED_SUBNET_IDS: %w", err)
+		}
+	}
+
+	if action == armstorage.DefaultActionAllow {
+		if len(ranges) > 0 || len(subnets) > 0 {
+			log.Println("Warning: ALLOWED_IP_RANGES and ALLOWED_SUBNET_IDS have no effect while NETWORK_DEFAULT_ACTION is Allow")
+		}
+		log.Println("Warning: the storage account will be publicly accessible from any network, set NETWORK_DEFAULT_ACTION=Deny to restrict it")
+	}
+	return nil
+}
+
+// validateSubnetID checks that id is a full virtual network subnet resource ID
+func validateSubnetID(id string) error {
+	resourceID, err := arm.ParseResourceID(id)
+	if err != nil || !strings.EqualFold(resourceID.ResourceType.String(), "Microsoft.Network/virtualNetworks/subnets") ||
+		resourceID.SubscriptionID == "" || resourceID.ResourceGroupName == "" {
+		return fmt.Errorf("%q is not a subnet resource ID, expected /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>", id)
+	}
+	return nil
+}
+
+// buildNetworkRuleSet builds the Storage Account firewall rules. It returns nil, leaving
+// the Azure default of allowing all networks, when no network settings are configured.
+// Trusted Azure services always bypass the rules so the Function App keeps working
+func buildNetworkRuleSet(cfg Config) (*armstorage.NetworkRuleSet, error) {
+	action, err := parseDefaultAction(cfg.NetworkDefaultAction)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := parseIPRanges(cfg.AllowedIPRanges)
+	if err != nil {
+		return nil, err
+	}
+	subnets := splitList(cfg.AllowedSubnetIDs)
+	if action == armstorage.DefaultActionAllow && len(ranges) == 0 && len(subnets) == 0 {
+		return nil, nil
+	}
+
+	rules := &armstorage.NetworkRuleSet{
+		DefaultAction:       to.Ptr(action),
+		Bypass:              to.Ptr(armstorage.BypassAzureServices),
+		IPRules:             []*armstorage.IPRule{},
+		VirtualNetworkRules: []*armstorage.VirtualNetworkRule{},
+	}
+	for _, r := range ranges {
+		rules.IPRules = append(rules.IPRules, &armstorage.IPRule{
+			IPAddressOrRange: to.Ptr(r),
+			Action:           to.Ptr("Allow"),
+		})
+	}
+	for _, id := range subnets {
+		rules.VirtualNetworkRules = append(rules.VirtualNetworkRules, &armstorage.VirtualNetworkRule{
+			VirtualNetworkResourceID: to.Ptr(id),
+			Action:                   to.Ptr("Allow"),
+		})
+	}
+	return rules, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// Plan actions, as a deployment would apply them to each resource
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planNoop   = "no-op"
+)
+
+// planChange is one setting of a resource, as it is in Azure and as configured
+type planChange struct {
+	Field   string
+	Current string
+	Desired string
+}
+
+// resourcePlan is what a deployment would do to one resource
+type resourcePlan struct {
+	Type    string
+	Name    string
+	Action  string
+	Changes []planChange
+	Note    string
+}
+
+// diffResource compares the fields of a resource with the configuration. A missing
+// resource is created with every field; an existing one is updated when any field
+// differs, ignoring case as Azure does
+func diffResource(resourceType, name string, exists bool, fields []planChange) resourcePlan {
+	plan := resourcePlan{Type: resourceType, Name: name, Action: planCreate}
+	if !exists {
+		for _, f := range fields {
+			plan.Changes = append(plan.Changes, planChange{Field: f.Field, Desired: f.Desired})
+		}
+		return plan
+	}
+	for _, f := range fields {
+		if !strings.EqualFold(f.Current, f.Desired) {
+			plan.Changes = append(plan.Changes, f)
+		}
+	}
+	plan.Action = planUpdate
+	if len(plan.Changes) == 0 {
+		plan.Action = planNoop
+	}
+	return plan
+}
+
+// Plan prints how the resource group, storage account and Function App in Azure differ
+// from the configuration, and whether a deployment would create, update or leave each
+// of them, like a Terraform plan. With DEPLOYMENTS every entry is planned. Only
+// read-only calls are made, so nothing is changed and the az and func CLIs are not needed
+func Plan(ctx context.Context, config Config) (err error) {
+	steps := stepTracker{parent: ctx, timeout: config.StepTimeout}
+	defer func() { steps.finish(err) }()
+
+	steps.begin(StepValidateConfig, "")
+	targets, err := parseDeployments(config)
+	if err != nil {
+		return &StepError{Step: StepValidateConfig, Err: err}
+	}
+	if targets == nil {
+		targets = []Config{config}
+	}
+	for i := range targets {
+		if err := validateConfig(&targets[i]); err != nil {
+			return &StepError{Step: StepValidateConfig, Err: err}
+		}
+	}
+	config = targets[0]
+
+	steps.begin(StepCredentials, "")
+	cred, err := newCredential(config)
+	if err != nil {
+		return &StepError{Step: StepCredentials, Err: err}
+	}
+	steps.begin(StepInitClients, config.AzureSubscriptionID)
+	if err := initClients(config, cred); err != nil {
+		return &StepError{Step: StepInitClients, Err: err}
+	}
+	stepCtx := steps.begin(StepVerifyCredential, config.AzureSubscriptionID)
+	if err := verifyCredential(stepCtx, config); err != nil {
+		return &StepError{Step: StepVerifyCredential, Err: err}
+	}
+
+	plans := []resourcePlan{}
+	for _, target := range targets {
+		stepCtx = steps.begin(StepPlan, target.AzureResourceGroupName)
+		for _, planResource := range []func(context.Context, Config) (resourcePlan, error){
+			planResourceGroup, planStorageAccount, planFunctionApp,
+		} {
+			plan, err := planResource(stepCtx, target)
+			if err != nil {
+				return &StepError{Step: StepPlan, Err: err}
+			}
+			plans = append(plans, plan)
+		}
+	}
+	printPlan(plans)
+	return nil
+}
+
+// planResourceGroup compares the resource group's location and tags. Its tags are
+// replaced on deploy unless REUSE_RESOURCE_GROUP or USE_EXISTING_RESOURCE_GROUP is set
+func planResourceGroup(ctx context.Context, cfg Config) (resourcePlan, error) {
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return resourcePlan{}, err
+	}
+	group, err := lookupResourceGroup(ctx, cfg)
+	if err != nil {
+		return resourcePlan{}, fmt.Errorf("failed to get resource group %s: %w", cfg.AzureResourceGroupName, err)
+	}
+	fields := []planChange{
+		{Field: "location", Desired: cfg.AzureLocation},
+		{Field: "tags", Desired: formatTags(tags)},
+	}
+	if group != nil {
+		fields[0].Current = normalizeLocation(derefString(group.Location))
+		fields[1].Current = formatTags(group.Tags)
+	}
+	plan := diffResource("resource group", cfg.AzureResourceGroupName, group != nil, fields)
+	switch {
+	case group == nil && cfg.ReuseResourceGroup == reuseGroupRequired:
+		plan.Note = "REUSE_RESOURCE_GROUP is set, the deployment fails because the group does not exist"
+	case plan.Action == planUpdate && cfg.ReuseResourceGroup != reuseGroupOff && fields[0].Current != fields[0].Desired:
+		plan.Note = "REUSE_RESOURCE_GROUP is set, the deployment fails because the group is in another location"
+	case plan.Action == planUpdate && cfg.ReuseResourceGroup != reuseGroupOff:
+		plan.Note = "the existing group is used without modifying it, these differences are not applied"
+	case plan.Action == planUpdate && fields[0].Current != fields[0].Desired:
+		plan.Note = "the location of a resource group cannot be changed, the deployment fails"
+	}
+	return plan, nil
+}
+
+// planStorageAccount compares the storage account's location, SKU, kind, access tier
+// and tags. An existing account is reused as it is, so its differences are reported
+// but never applied
+func planStorageAccount(ctx context.Context, cfg Config) (resourcePlan, error) {
+	params, err := storageAccountParameters(cfg)
+	if err != nil {
+		return resourcePlan{}, err
+	}
+	account, err := findExistingStorageAccount(ctx, cfg)
+	if err != nil {
+		return resourcePlan{}, fmt.Errorf("failed to get storage account %s: %w", cfg.AzureStorageAccountName, err)
+	}
+	fields := []planChange{
+		{Field: "location", Desired: cfg.AzureLocation},
+		{Field: "sku", Desired: string(*params.SKU.Name)},
+		{Field: "kind", Desired: string(*params.Kind)},
+		{Field: "access tier", Desired: derefAccessTier(params.Properties.AccessTier)},
+		{Field: "tags", Desired: formatTags(params.Tags)},
+	}
+	if account != nil {
+		fields[0].Current = normalizeLocation(derefString(account.Location))
+		if account.SKU != nil && account.SKU.Name != nil {
+			fields[1].Current = string(*account.SKU.Name)
+		}
+		if account.Kind != nil {
+			fields[2].Current = string(*account.Kind)
+		}
+		if account.Properties != nil {
+			fields[3].Current = derefAccessTier(account.Properties.AccessTier)
+		}
+		fields[4].Current = formatTags(account.Tags)
+	}
+	plan := diffResource("storage account", cfg.AzureStorageAccountName, account != nil, fields)
+	switch {
+	case account == nil && cfg.UseExistingStorageAccount:
+		plan.Note = "USE_EXISTING_STORAGE_ACCOUNT is set, the deployment fails because the account does not exist"
+	case plan.Action == planUpdate:
+		plan.Note = "the existing account is reused, these differences are not applied"
+	}
+	return plan, nil
+}
+
+// planFunctionApp compares the Function App's location, operating system, runtime and
+// tags. The runtime is only read from the Linux site configuration, since Windows apps
+// select it with app settings
+func planFunctionApp(ctx context.Context, cfg Config) (resourcePlan, error) {
+	tags, err := parseTags(cfg.ResourceTags)
+	if err != nil {
+		return resourcePlan{}, err
+	}
+	site, err := getFunctionAppResource(ctx, cfg)
+	if err != nil {
+		return resourcePlan{}, err
+	}
+	osType := functionAppOS(cfg)
+	fields := []planChange{
+		{Field: "location", Desired: cfg.AzureLocation},
+		{Field: "os type", Desired: osType},
+		{Field: "tags", Desired: formatTags(tags)},
+	}
+	if osType == osTypeLinux {
+		fields = append(fields, planChange{Field: "runtime", Desired: desiredLinuxFxVersion(cfg)})
+	}
+	if site != nil {
+		fields[0].Current = normalizeLocation(derefString(site.Location))
+		fields[1].Current = functionAppSite{Kind: derefString(site.Kind)}.osType()
+		fields[2].Current = formatTags(site.Tags)
+		if osType == osTypeLinux {
+			fields[3].Current = siteLinuxFxVersion(site)
+		}
+	}
+	plan := diffResource("function app", cfg.AzureFunctionAppName, site != nil, fields)
+	if site == nil {
+		return plan, nil
+	}
+	switch cfg.IfAppExists {
+	case appExistsUpdate:
+		if plan.Action == planUpdate {
+			plan.Note = "only the runtime is updated, with UPDATE_RUNTIME_VERSION; the other differences are not applied"
+		}
+	case appExistsRecreate:
+		plan.Action = planCreate
+		plan.Note = "IF_APP_EXISTS is recreate, the app is deleted and created again"
+	default:
+		plan.Note = "the deployment stops because the app exists, set IF_APP_EXISTS to update or recreate to deploy over it"
+	}
+	return plan, nil
+}
+
+// getFunctionAppResource reads the Function App with the resources API, returning nil
+// if it does not exist
+func getFunctionAppResource(ctx context.Context, cfg Config) (*armresources.GenericResource, error) {
+	resp, err := resourcesClient.GetByID(ctx, functionAppID(cfg), armWebAPIVersion, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get Function App %s: %w", cfg.AzureFunctionAppName, err)
+	}
+	return &resp.GenericResource, nil
+}
+
+// desiredLinuxFxVersion returns the linuxFxVersion a Linux Function App is created with
+func desiredLinuxFxVersion(cfg Config) string {
+	if cfg.ContainerImage != "" {
+		return "DOCKER|" + cfg.ContainerImage
+	}
+	return strings.ToUpper(cfg.FunctionRuntime) + "|" + cfg.FunctionRuntimeVersion
+}
+
+// siteLinuxFxVersion returns the linuxFxVersion of a site's configuration, or "" when
+// it has none
+func siteLinuxFxVersion(site *armresources.GenericResource) string {
+	properties, _ := site.Properties.(map[string]any)
+	siteConfig, _ := properties["siteConfig"].(map[string]any)
+	version, _ := siteConfig["linuxFxVersion"].(string)
+	return version
+}
+
+// printPlan writes the plan to stdout, one resource per line followed by its changed
+// fields: + for a field set on a new resource, ~ for a field that differs
+func printPlan(plans []resourcePlan) {
+	counts := map[string]int{}
+	for _, plan := range plans {
+		counts[plan.Action]++
+		fmt.Printf("%s %s: %s\n", plan.Type, plan.Name, plan.Action)
+		for _, change := range plan.Changes {
+			if plan.Action == planCreate && change.Current == "" {
+				fmt.Printf("  + %s: %s\n", change.Field, valueOrNone(change.Desired))
+			} else {
+				fmt.Printf("  ~ %s: %s -> %s\n", change.Field, valueOrNone(change.Current), valueOrNone(change.Desired))
+			}
+		}
+		if plan.Note != "" {
+			fmt.Printf("  note: %s\n", plan.Note)
+		}
+	}
+	fmt.Printf("Plan: %d to create, %d to update, %d unchanged\n", counts[planCreate], counts[planUpdate], counts[planNoop])
+	log.Println("Plan only, nothing was changed")
+}
+
+// derefAccessTier returns the access tier, or "" for an account without one
+func derefAccessTier(tier *armstorage.AccessTier) string {
+	if tier == nil {
+		return ""
+	}
+	return string(*tier)
+}
+
+// valueOrNone renders an empty plan value as (none)
+func valueOrNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
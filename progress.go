@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// defaultProgressInterval is the time between progress reports while waiting for a
+// long-running Azure operation when PROGRESS_INTERVAL is not set
+const defaultProgressInterval = 15 * time.Second
+
+// minPollFrequency is the shortest POLL_FREQUENCY accepted, to keep polling from
+// hitting the Azure Resource Manager request limits
+const minPollFrequency = time.Second
+
+// ProgressFunc is called periodically while a deployment step waits for a long-running
+// Azure operation, with the time spent waiting so far
+type ProgressFunc func(step string, elapsed time.Duration)
+
+// logProgress is the default ProgressFunc, logging a heartbeat
+func logProgress(step string, elapsed time.Duration) {
+	log.Printf("Still waiting for step %q to complete (%s elapsed)", step, elapsed.Round(time.Second))
+}
+
+// pollWithProgress polls a long-running operation every POLL_FREQUENCY until it is
+// done, reporting progress to cfg.ProgressFunc every PROGRESS_INTERVAL in place of
+// PollUntilDone. Without POLL_FREQUENCY it polls once per report
+func pollWithProgress[T any](ctx context.Context, cfg Config, step string, poller *runtime.Poller[T]) (T, error) {
+	progress := cfg.ProgressFunc
+	if progress == nil {
+		progress = logProgress
+	}
+	interval := cfg.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	frequency := cfg.PollFrequency
+	if frequency <= 0 {
+		frequency = interval
+	}
+
+	start := time.Now()
+	reported := start
+	for !poller.Done() {
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-time.After(frequency):
+		}
+		if _, err := poller.Poll(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+		if !poller.Done() && time.Since(reported) >= interval {
+			progress(step, time.Since(start))
+			reported = time.Now()
+		}
+	}
+	return poller.Result(ctx)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// storageAccountsUsage names the usage counting the Storage Accounts of a subscription
+// in a location
+const storageAccountsUsage = "StorageAccounts"
+
+// checkStorageQuota reads the Storage Account usage of the subscription in
+// AZURE_LOCATION and fails when creating another account would exceed the limit, so
+// the run stops before anything is provisioned. An account that already exists in its
+// resource group is reused and needs no quota. The call is read-only, so it also runs
+// in dry-run mode
+func checkStorageQuota(ctx context.Context, cfg Config) error {
+	if cfg.UseExistingStorageAccount {
+		log.Println("Skipping the storage account quota check, USE_EXISTING_STORAGE_ACCOUNT creates no account")
+		return nil
+	}
+
+	usage, err := storageAccountUsage(ctx, cfg.AzureLocation)
+	if err != nil {
+		return err
+	}
+	if usage == nil || usage.CurrentValue == nil || usage.Limit == nil {
+		log.Printf("Warning: no storage account usage reported for location %s, skipping the quota check", cfg.AzureLocation)
+		return nil
+	}
+	current, limit := *usage.CurrentValue, *usage.Limit
+	log.Printf("Storage account quota in %s: %d of %d used", cfg.AzureLocation, current, limit)
+
+	switch {
+	case current < limit-1:
+		return nil
+	case current == limit-1:
+		log.Printf("Warning: a new storage account uses the last of the %d allowed in %s", limit, cfg.AzureLocation)
+		return nil
+	}
+	existing, err := findExistingStorageAccount(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		log.Println("Storage account quota reached, but the existing account is reused:", *existing.ID)
+		return nil
+	}
+	return fmt.Errorf("storage account quota of subscription %s in %s is reached (%d of %d used), delete unused accounts, request a quota increase or set USE_EXISTING_STORAGE_ACCOUNT",
+		cfg.AzureSubscriptionID, cfg.AzureLocation, current, limit)
+}
+
+// storageAccountUsage returns the Storage Account usage of the subscription in
+// location, or nil if Azure reports none
+func storageAccountUsage(ctx context.Context, location string) (*armstorage.Usage, error) {
+	pager := usagesClient.NewListByLocationPager(location, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list storage usages in %s: %w", location, err)
+		}
+		for _, usage := range page.Value {
+			if usage.Name != nil && derefString(usage.Name.Value) == storageAccountsUsage {
+				return usage, nil
+			}
+		}
+	}
+	return nil, nil
+}
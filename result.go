@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// DeploymentResult is the machine-readable summary returned by Deploy and written to
+// OUTPUT_FILE after a successful deployment
+type DeploymentResult struct {
+	ResourceGroupID  string           `json:"resourceGroupId"`
+	StorageAccountID string           `json:"storageAccountId"`
+	StorageEndpoints StorageEndpoints `json:"storageEndpoints"`
+	// StorageConnectionString is only filled in when SHOW_SECRETS is set
+	StorageConnectionString string           `json:"storageConnectionString,omitempty"`
+	BlobContainerIDs        []string         `json:"blobContainerIds,omitempty"`
+	FunctionAppName         string           `json:"functionAppName"`
+	FunctionAppID           string           `json:"functionAppId"`
+	FunctionAppUpdated      bool             `json:"functionAppUpdated,omitempty"`
+	DefaultHostName         string           `json:"defaultHostName,omitempty"`
+	HostingPlanID           string           `json:"hostingPlanId,omitempty"`
+	OSType                  string           `json:"osType,omitempty"`
+	PrincipalID             string           `json:"principalId,omitempty"`
+	UserAssignedIdentityID  string           `json:"userAssignedIdentityId,omitempty"`
+	AppInsightsID           string           `json:"appInsightsId,omitempty"`
+	AllowedOrigins          []string         `json:"allowedOrigins,omitempty"`
+	Functions               []FunctionResult `json:"functions,omitempty"`
+	DeployedAt              time.Time        `json:"deployedAt"`
+	CleanedUp               bool             `json:"cleanedUp"`
+}
+
+// StorageEndpoints holds the primary service endpoints of the Storage Account
+type StorageEndpoints struct {
+	Blob  string `json:"blob,omitempty"`
+	Queue string `json:"queue,omitempty"`
+	Table string `json:"table,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
+// storageEndpointsFrom extracts the primary endpoints from Storage Account properties
+func storageEndpointsFrom(account *armstorage.Account) StorageEndpoints {
+	if account.Properties == nil || account.Properties.PrimaryEndpoints == nil {
+		return StorageEndpoints{}
+	}
+	endpoints := account.Properties.PrimaryEndpoints
+	return StorageEndpoints{
+		Blob:  derefString(endpoints.Blob),
+		Queue: derefString(endpoints.Queue),
+		Table: derefString(endpoints.Table),
+		File:  derefString(endpoints.File),
+	}
+}
+
+// writeDeploymentResult writes a result, or the list of results of several deployments,
+// as indented JSON to path, or to stdout when path is "-". The file is readable by the
+// owner only, as it holds the storage connection string when SHOW_SECRETS is set
+func writeDeploymentResult(path string, result any) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	if err := writePrivateFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write deployment result to %s: %v", path, err)
+	}
+	return nil
+}
+
+// derefString returns the value of s, or "" when it is nil
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteDeploymentResultIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+	path := filepath.Join(t.TempDir(), "result.json")
+	// An existing world-readable file is restricted too
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := DeploymentResult{StorageConnectionString: "AccountKey=secret"}
+	if err := writeDeploymentResult(path, result); err != nil {
+		t.Fatalf("writeDeploymentResult: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("result file mode = %o, want 600", mode)
+	}
+}
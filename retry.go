@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// transientStatusCodes are the HTTP status codes worth retrying: throttling and
+// temporary server-side failures
+var transientStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// maxRetryDelay caps the exponential backoff so large MAX_RETRIES values do not lead to
+// unbounded waits
+const maxRetryDelay = time.Minute
+
+// withRetry calls fn, retrying up to cfg.MaxRetries times when it fails with a transient
+// Azure error. The delay doubles from cfg.RetryBaseDelay with jitter added, unless the
+// response carries a Retry-After header, which is honored instead
+func withRetry[T any](ctx context.Context, cfg Config, operation string, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := fn()
+		if err == nil {
+			return result, nil
+		}
+
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || !transientStatusCodes[respErr.StatusCode] {
+			return result, err
+		}
+		if attempt >= cfg.MaxRetries {
+			return result, fmt.Errorf("%s failed after %d retries: %w", operation, attempt, err)
+		}
+
+		delay := retryDelay(respErr, cfg.RetryBaseDelay, attempt)
+		log.Printf("%s failed with transient status %d, retrying in %s (retry %d of %d)",
+			operation, respErr.StatusCode, delay.Round(time.Millisecond), attempt+1, cfg.MaxRetries)
+
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay returns how long to wait before the next attempt
+func retryDelay(respErr *azcore.ResponseError, base time.Duration, attempt int) time.Duration {
+	if respErr.RawResponse != nil {
+		if d, ok := parseRetryAfter(respErr.RawResponse.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+
+	backoff := maxRetryDelay
+	if attempt < 32 && base < maxRetryDelay>>attempt {
+		backoff = base << attempt
+	}
+	// Add up to 50% jitter so concurrent runs do not retry in lockstep
+	return backoff + rand.N(backoff/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
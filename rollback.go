@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// rollbackAction undoes the creation of a single resource
+type rollbackAction struct {
+	ResourceID string
+	Undo       func(ctx context.Context) error
+}
+
+// rollbackStack records the resources created by a run so they can be torn down in
+// reverse order of creation when a later step fails. It is guarded by mu as PARALLEL
+// creates resources concurrently
+type rollbackStack struct {
+	mu      sync.Mutex
+	actions []rollbackAction
+}
+
+// push records a created resource and the action that deletes it
+func (r *rollbackStack) push(resourceID string, undo func(ctx context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, rollbackAction{ResourceID: resourceID, Undo: undo})
+}
+
+// run deletes every recorded resource, newest first. Failures are logged and do not
+// stop the remaining resources from being rolled back
+func (r *rollbackStack) run(ctx context.Context) {
+	// Roll back even if the deployment context was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := r.actions[i]
+		log.Println("Rolling back:", action.ResourceID)
+		if err := action.Undo(ctx); err != nil {
+			log.Printf("Failed to roll back %s: %v", action.ResourceID, err)
+			continue
+		}
+		log.Println("Rolled back:", action.ResourceID)
+	}
+	r.actions = nil
+}
+
+// deleteResourceGroup deletes the configured Resource Group and waits for completion
+func deleteResourceGroup(ctx context.Context, cfg Config) error {
+	pollerResp, err := resourceGroupClient.BeginDelete(ctx, cfg.AzureResourceGroupName, nil)
+	if err != nil {
+		return err
+	}
+	_, err = pollWithProgress(ctx, cfg, StepCleanup, pollerResp)
+	return err
+}
+
+// deleteStorageAccount deletes the configured Storage Account
+func deleteStorageAccount(ctx context.Context, cfg Config) error {
+	_, err := accountsClient.Delete(ctx, cfg.AzureResourceGroupName, cfg.AzureStorageAccountName, nil)
+	return err
+}
+
+// deleteBlobContainer deletes the blob container with the given resource ID from the
+// configured Storage Account
+func deleteBlobContainer(ctx context.Context, cfg Config, id string) error {
+	name := id[strings.LastIndex(id, "/")+1:]
+	_, err := blobContainersClient.Delete(ctx, storageResourceGroup(cfg), cfg.AzureStorageAccountName, name, nil)
+	return err
+}
+
+// deleteFunctionApp deletes the configured Function App using `az functionapp delete`
+func deleteFunctionApp(ctx context.Context, cfg Config) error {
+	output, err := runCommand(ctx, cfg, "", "az", "functionapp", "delete",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.AzureFunctionAppName,
+	)
+	if err != nil {
+		return fmt.Errorf("az functionapp delete failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	return nil
+}
+
+// deleteHostingPlan deletes the configured hosting plan using `az functionapp plan delete`
+func deleteHostingPlan(ctx context.Context, cfg Config) error {
+	output, err := runCommand(ctx, cfg, "", "az", "functionapp", "plan", "delete",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.PlanName,
+		"--yes",
+	)
+	if err != nil {
+		return fmt.Errorf("az functionapp plan delete failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	return nil
+}
+
+// hostingPlanID builds the Azure resource ID of the configured hosting plan
+func hostingPlanID(cfg Config) string {
+	return fmt.Sprintf("%s/providers/Microsoft.Web/serverfarms/%s", resourceGroupID(cfg), cfg.PlanName)
+}
+
+// storageResourceGroup returns the resource group of the Storage Account, which is
+// STORAGE_RESOURCE_GROUP for a shared existing account and the deployment's otherwise
+func storageResourceGroup(cfg Config) string {
+	if cfg.StorageResourceGroup != "" {
+		return cfg.StorageResourceGroup
+	}
+	return cfg.AzureResourceGroupName
+}
+
+// storageAccountID builds the Azure resource ID of the configured Storage Account
+func storageAccountID(cfg Config) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s",
+		cfg.AzureSubscriptionID, storageResourceGroup(cfg), cfg.AzureStorageAccountName)
+}
+
+// functionAppID builds the Azure resource ID of the configured Function App
+func functionAppID(cfg Config) string {
+	return fmt.Sprintf("%s/providers/Microsoft.Web/sites/%s", resourceGroupID(cfg), cfg.AzureFunctionAppName)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// packageContainer is the blob container run-from-package deployments are uploaded to,
+// the same one az uses for its own package deployments
+const packageContainer = "function-releases"
+
+// packageSASValidity is how long the read-only SAS URL of a package stays valid when
+// the app has no managed identity to read it with. The host downloads the package again
+// whenever it restarts, so the app must be redeployed before the URL expires
+const packageSASValidity = 30 * 24 * time.Hour
+
+// packageReaderRole is the role the Function App's managed identity is granted on the
+// Storage Account to read its package
+const packageReaderRole = "Storage Blob Data Reader"
+
+// publishViaRunFromPackage zips the Function App project directory, uploads it to the
+// function-releases container of the Storage Account and points the
+// WEBSITE_RUN_FROM_PACKAGE app setting at the blob. When the app has a managed identity
+// it reads the blob with it, otherwise the setting holds a short-lived read-only SAS
+// URL. Changing the setting restarts the app, which then runs the package as is
+func publishViaRunFromPackage(ctx context.Context, cfg Config, result *DeploymentResult) error {
+	blobName := fmt.Sprintf("%s-%s.zip", cfg.AzureFunctionAppName, time.Now().UTC().Format("20060102150405"))
+	if cfg.DryRun {
+		planDryRun("zip %s, upload it to %s/%s in storage account %s and set WEBSITE_RUN_FROM_PACKAGE on Function App %s",
+			cfg.FunctionProjectDir, packageContainer, blobName, cfg.AzureStorageAccountName, cfg.AzureFunctionAppName)
+		return nil
+	}
+
+	pkg, err := zipDirectory(cfg.FunctionProjectDir)
+	if err != nil {
+		return fmt.Errorf("failed to package project directory: %v", err)
+	}
+	file, err := os.CreateTemp("", "functionapp-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to write package: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(pkg)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write package: %v", err)
+	}
+	log.Printf("Packaged %s (%d bytes) for run-from-package", cfg.FunctionProjectDir, len(pkg))
+
+	// az reads the account key itself, so only the account name is needed
+	storageArgs := []string{"--account-name", cfg.AzureStorageAccountName, "--auth-mode", "key"}
+	commands := [][]string{
+		{"storage", "container", "create", "--name", packageContainer},
+		{"storage", "blob", "upload", "--container-name", packageContainer, "--name", blobName, "--file", file.Name(), "--overwrite"},
+	}
+	for _, args := range commands {
+		output, err := runCommand(ctx, cfg, "", "az", append(args, storageArgs...)...)
+		if err != nil {
+			return fmt.Errorf("az %s failed: %v\nOutput: %s", strings.Join(args[:3], " "), err, outputTail(output))
+		}
+	}
+	log.Printf("Package uploaded to %s/%s", packageContainer, blobName)
+
+	identityID, principalID, err := packageIdentity(ctx, cfg, result)
+	if err != nil {
+		return err
+	}
+	if identityID != "" {
+		if err := grantPackageReader(ctx, cfg, principalID); err != nil {
+			return err
+		}
+		output, err := runCommand(ctx, cfg, "", "az", append([]string{
+			"storage", "blob", "url",
+			"--container-name", packageContainer,
+			"--name", blobName,
+			"--output", "tsv",
+		}, storageArgs...)...)
+		if err != nil {
+			return fmt.Errorf("az storage blob url failed: %v\nOutput: %s", err, outputTail(output))
+		}
+		return configureAppSettings(ctx, cfg, map[string]string{
+			"WEBSITE_RUN_FROM_PACKAGE":                     strings.TrimSpace(string(output)),
+			"WEBSITE_RUN_FROM_PACKAGE_BLOB_MI_RESOURCE_ID": identityID,
+		})
+	}
+
+	// The SAS URL is a secret, so it is never streamed to the log
+	expiry := time.Now().UTC().Add(packageSASValidity)
+	quiet := cfg
+	quiet.Verbose = false
+	output, err := runCommand(ctx, quiet, "", "az", append([]string{
+		"storage", "blob", "generate-sas",
+		"--container-name", packageContainer,
+		"--name", blobName,
+		"--permissions", "r",
+		"--expiry", expiry.Format("2006-01-02T15:04Z"),
+		"--https-only",
+		"--full-uri",
+		"--output", "tsv",
+	}, storageArgs...)...)
+	if err != nil {
+		return fmt.Errorf("az storage blob generate-sas failed: %v", err)
+	}
+	log.Printf("Warning: the package SAS URL expires on %s, redeploy before then or set ASSIGN_IDENTITY so the app reads the package with its managed identity",
+		expiry.Format(time.DateOnly))
+
+	return configureAppSettings(ctx, cfg, map[string]string{"WEBSITE_RUN_FROM_PACKAGE": strings.TrimSpace(string(output))})
+}
+
+// packageIdentity returns the WEBSITE_RUN_FROM_PACKAGE_BLOB_MI_RESOURCE_ID value of the
+// managed identity the app reads its package with, and that identity's principal ID:
+// the system-assigned identity with ASSIGN_IDENTITY, otherwise USER_ASSIGNED_IDENTITY_ID.
+// Both are empty when the app has no managed identity
+func packageIdentity(ctx context.Context, cfg Config, result *DeploymentResult) (string, string, error) {
+	if cfg.AssignIdentity && result.PrincipalID != "" {
+		return "SystemAssigned", result.PrincipalID, nil
+	}
+	if cfg.UserAssignedIdentityID == "" {
+		return "", "", nil
+	}
+	output, err := runCommand(ctx, cfg, "", "az", "identity", "show",
+		"--ids", cfg.UserAssignedIdentityID,
+		"--query", "principalId",
+		"--output", "tsv")
+	if err != nil {
+		return "", "", fmt.Errorf("az identity show failed: %v\nOutput: %s", err, outputTail(output))
+	}
+	return cfg.UserAssignedIdentityID, strings.TrimSpace(string(output)), nil
+}
+
+// grantPackageReader grants the managed identity read access to the package blobs with
+// `az role assignment create`. An existing assignment is left as is
+func grantPackageReader(ctx context.Context, cfg Config, principalID string) error {
+	output, err := runCommand(ctx, cfg, "", "az", "role", "assignment", "create",
+		"--assignee-object-id", principalID,
+		"--assignee-principal-type", "ServicePrincipal",
+		"--role", packageReaderRole,
+		"--scope", storageAccountID(cfg),
+		"--output", "none")
+	if err != nil && !strings.Contains(strings.ToLower(string(output)), "already exists") {
+		return fmt.Errorf("az role assignment create failed, the deploying identity needs permission to assign %q on storage account %s: %v\nOutput: %s",
+			packageReaderRole, cfg.AzureStorageAccountName, err, outputTail(output))
+	}
+	log.Printf("Granted %s on storage account %s to the Function App identity", packageReaderRole, cfg.AzureStorageAccountName)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// appSettingsArgs returns the settings passed to az functionapp config appsettings set
+func appSettingsArgs(runner *fakeCommandRunner) []string {
+	for _, call := range runner.calls {
+		if len(call.args) > 3 && call.args[0] == "functionapp" && call.args[3] == "set" {
+			i := slices.Index(call.args, "--settings")
+			return call.args[i+1:]
+		}
+	}
+	return nil
+}
+
+func TestRunFromPackageUsesManagedIdentity(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "host.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+		if slices.Contains(call.args, "url") {
+			return []byte("https://sttest.blob.core.windows.net/function-releases/pkg.zip\n"), nil
+		}
+		return nil, nil
+	}}
+	cfg := testConfig()
+	cfg.FunctionProjectDir = dir
+	cfg.AssignIdentity = true
+	cfg.CommandRunner = runner
+
+	if err := publishViaRunFromPackage(context.Background(), cfg, &DeploymentResult{PrincipalID: "principal"}); err != nil {
+		t.Fatalf("publishViaRunFromPackage: %v", err)
+	}
+	for _, call := range runner.calls {
+		if slices.Contains(call.args, "generate-sas") {
+			t.Errorf("SAS generated although the app has a managed identity: %v", call.args)
+		}
+	}
+	granted := slices.ContainsFunc(runner.calls, func(call commandCall) bool {
+		return slices.Contains(call.args, "assignment") && slices.Contains(call.args, "principal")
+	})
+	if !granted {
+		t.Error("no role assignment created for the Function App identity")
+	}
+	want := []string{
+		"WEBSITE_RUN_FROM_PACKAGE=https://sttest.blob.core.windows.net/function-releases/pkg.zip",
+		"WEBSITE_RUN_FROM_PACKAGE_BLOB_MI_RESOURCE_ID=SystemAssigned",
+	}
+	if got := appSettingsArgs(runner); !slices.Equal(got, want) {
+		t.Errorf("app settings = %v, want %v", got, want)
+	}
+}
+
+func TestRunFromPackageFallsBackToShortSAS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "host.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeCommandRunner{respond: func(call commandCall) ([]byte, error) {
+		if slices.Contains(call.args, "generate-sas") {
+			return []byte("https://sttest.blob.core.windows.net/function-releases/pkg.zip?sig=secret\n"), nil
+		}
+		return nil, nil
+	}}
+	cfg := testConfig()
+	cfg.FunctionProjectDir = dir
+	cfg.CommandRunner = runner
+
+	if err := publishViaRunFromPackage(context.Background(), cfg, &DeploymentResult{}); err != nil {
+		t.Fatalf("publishViaRunFromPackage: %v", err)
+	}
+	got := appSettingsArgs(runner)
+	if len(got) != 1 || !strings.HasSuffix(got[0], "?sig=secret") {
+		t.Errorf("app settings = %v, want only the SAS URL", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// validateStorageFeatures checks ENABLE_HIERARCHICAL_NAMESPACE, ENABLE_SFTP and
+// ENABLE_LARGE_FILE_SHARES against each other and the account SKU and kind. SFTP is
+// only served from a hierarchical namespace, which standard StorageV2 and premium
+// BlockBlobStorage accounts have. Large file shares are a standard StorageV2 feature
+// limited to locally and zone-redundant SKUs
+func validateStorageFeatures(cfg Config, kind armstorage.Kind, sku armstorage.SKUName) error {
+	if cfg.EnableSFTP && !cfg.EnableHNS {
+		return errors.New("ENABLE_SFTP requires ENABLE_HIERARCHICAL_NAMESPACE, SFTP is only available on accounts with a hierarchical namespace")
+	}
+	if cfg.EnableHNS && kind != armstorage.KindStorageV2 && kind != armstorage.KindBlockBlobStorage {
+		return fmt.Errorf("ENABLE_HIERARCHICAL_NAMESPACE requires STORAGE_KIND %s or %s, got %s",
+			armstorage.KindStorageV2, armstorage.KindBlockBlobStorage, kind)
+	}
+	if cfg.EnableLargeFileShares && kind != armstorage.KindFileStorage {
+		if kind != armstorage.KindStorageV2 {
+			return fmt.Errorf("ENABLE_LARGE_FILE_SHARES requires STORAGE_KIND %s, got %s", armstorage.KindStorageV2, kind)
+		}
+		if sku != armstorage.SKUNameStandardLRS && sku != armstorage.SKUNameStandardZRS {
+			return fmt.Errorf("ENABLE_LARGE_FILE_SHARES requires STORAGE_SKU %s or %s, got %s",
+				armstorage.SKUNameStandardLRS, armstorage.SKUNameStandardZRS, sku)
+		}
+	}
+	return nil
+}
+
+// applyStorageFeatures sets the hierarchical namespace, SFTP and large file share
+// properties of a new account. Disabled features are left unset, which is Azure's
+// default
+func applyStorageFeatures(cfg Config, kind armstorage.Kind, props *armstorage.AccountPropertiesCreateParameters) {
+	if cfg.EnableHNS {
+		props.IsHnsEnabled = to.Ptr(true)
+		log.Println("Storage Account hierarchical namespace: enabled")
+	}
+	if cfg.EnableSFTP {
+		props.IsSftpEnabled = to.Ptr(true)
+		log.Println("Storage Account SFTP: enabled")
+	}
+	if cfg.EnableLargeFileShares {
+		if kind == armstorage.KindFileStorage {
+			log.Printf("Ignoring ENABLE_LARGE_FILE_SHARES, file shares of STORAGE_KIND %s are always large", kind)
+			return
+		}
+		props.LargeFileSharesState = to.Ptr(armstorage.LargeFileSharesStateEnabled)
+		log.Println("Storage Account large file shares: enabled")
+	}
+}
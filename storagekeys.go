@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+// storageConnectionString lists the keys of the Storage Account and builds a connection
+// string from its first key. The endpoint suffix is taken from the blob endpoint so the
+// string is valid in every Azure cloud
+func storageConnectionString(ctx context.Context, cfg Config, account *armstorage.Account) (string, error) {
+	resp, err := accountsClient.ListKeys(ctx, storageResourceGroup(cfg), cfg.AzureStorageAccountName, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys of storage account %s: %w", cfg.AzureStorageAccountName, err)
+	}
+	if len(resp.Keys) == 0 || resp.Keys[0].Value == nil {
+		return "", fmt.Errorf("storage account %s returned no access keys", cfg.AzureStorageAccountName)
+	}
+
+	suffix, err := endpointSuffix(storageEndpointsFrom(account).Blob, cfg.AzureStorageAccountName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("DefaultEndpointsProtocol=https;AccountName=%s;AccountKey=%s;EndpointSuffix=%s",
+		cfg.AzureStorageAccountName, *resp.Keys[0].Value, suffix), nil
+}
+
+// exportConnectionString writes the connection string to CONNECTION_STRING_OUTPUT,
+// readable and writable by the owner only. The key itself is never logged
+func exportConnectionString(cfg Config, connectionString string) error {
+	path := cfg.ConnectionStringOutput
+	if cfg.DryRun {
+		planDryRun("write the connection string of storage account %s to %s", cfg.AzureStorageAccountName, path)
+		return nil
+	}
+	if connectionString == "" {
+		return fmt.Errorf("no connection string to write to %s, the access keys of storage account %s could not be read",
+			path, cfg.AzureStorageAccountName)
+	}
+
+	if err := writePrivateFile(path, []byte(connectionString+"\n")); err != nil {
+		return fmt.Errorf("failed to write connection string to %s: %v", path, err)
+	}
+	log.Println("Storage Account connection string written to", path)
+	return nil
+}
+
+// writePrivateFile writes data to path, creating or truncating the file with mode 0600
+// so that only the owner can read the secrets it holds
+func writePrivateFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	// An existing file keeps its mode when opened, so restrict it before writing
+	err = file.Chmod(0o600)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// endpointSuffix extracts the storage endpoint suffix, such as core.windows.net, from a
+// blob endpoint of the form https://<account>.blob.<suffix>/
+func endpointSuffix(blobEndpoint, accountName string) (string, error) {
+	u, err := url.Parse(blobEndpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("malformed blob endpoint %q", blobEndpoint)
+	}
+	suffix, found := strings.CutPrefix(u.Hostname(), accountName+".blob.")
+	if !found {
+		return "", fmt.Errorf("unexpected blob endpoint %q for storage account %s", blobEndpoint, accountName)
+	}
+	return suffix, nil
+}
+
+// logStorageAccess logs the primary blob and queue endpoints and, when the connection
+// string is known, the connection string with its key redacted unless SHOW_SECRETS is set
+func logStorageAccess(cfg Config, endpoints StorageEndpoints, connectionString string) {
+	log.Println("Storage Account primary blob endpoint:", endpoints.Blob)
+	log.Println("Storage Account primary queue endpoint:", endpoints.Queue)
+	if connectionString == "" {
+		return
+	}
+	if !cfg.ShowSecrets {
+		connectionString = redactAccountKey(connectionString)
+	}
+	log.Println("Storage Account connection string:", connectionString)
+}
+
+// redactAccountKey replaces the AccountKey of a connection string with ****
+func redactAccountKey(connectionString string) string {
+	parts := strings.Split(connectionString, ";")
+	for i, part := range parts {
+		if key, _, found := strings.Cut(part, "="); found && strings.EqualFold(key, "AccountKey") {
+			parts[i] = key + "=****"
+		}
+	}
+	return strings.Join(parts, ";")
+}
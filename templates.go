@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// functionTemplates lists the `func new` templates known to Core Tools v4 for each runtime.
+// Runtimes missing from the map, such as java whose functions come from Maven archetypes,
+// are not checked
+var functionTemplates = map[string][]string{
+	"node": {
+		"HTTP trigger", "Timer trigger", "Azure Blob Storage trigger", "Azure Queue Storage trigger",
+		"Azure Event Hub trigger", "Azure Service Bus Queue trigger", "Azure Service Bus Topic trigger",
+		"Azure Cosmos DB trigger", "Azure Event Grid trigger", "Durable Functions orchestrator",
+		"Durable Functions activity", "Durable Functions entity", "Durable Functions HTTP starter",
+	},
+	"python": {
+		"HTTP trigger", "Timer trigger", "Blob trigger", "Queue trigger", "Event Hub trigger",
+		"Service Bus Queue trigger", "Service Bus Topic trigger", "Cosmos DB trigger", "Event Grid trigger",
+	},
+	"dotnet": {
+		"HttpTrigger", "TimerTrigger", "BlobTrigger", "QueueTrigger", "EventHubTrigger",
+		"ServiceBusQueueTrigger", "ServiceBusTopicTrigger", "CosmosDBTrigger", "EventGridTrigger",
+		"DurableFunctionsOrchestration",
+	},
+	"powershell": {
+		"HTTP trigger", "Timer trigger", "Azure Blob Storage trigger", "Azure Queue Storage trigger",
+		"Azure Event Hub trigger", "Azure Service Bus Queue trigger", "Azure Service Bus Topic trigger",
+		"Azure Cosmos DB trigger", "Azure Event Grid trigger", "Durable Functions orchestrator",
+		"Durable Functions activity", "Durable Functions HTTP starter",
+	},
+}
+
+// validateFunctionTemplate checks that FUNCTION_TEMPLATE is a template of the configured
+// runtime. Names are compared ignoring case and spaces, as `func new` does
+func validateFunctionTemplate(runtime, template string) error {
+	templates, ok := functionTemplates[runtime]
+	if !ok {
+		return nil
+	}
+	if slices.ContainsFunc(templates, func(t string) bool {
+		return normalizeTemplateName(t) == normalizeTemplateName(template)
+	}) {
+		return nil
+	}
+	return fmt.Errorf("FUNCTION_TEMPLATE %q is not a %s template, valid templates are: %s (set SKIP_TEMPLATE_VALIDATION=1 to use a template not listed here)",
+		template, runtime, strings.Join(templates, ", "))
+}
+
+// normalizeTemplateName lowercases a template name and removes its spaces
+func normalizeTemplateName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", ""))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultVerifyTimeout bounds the post-publish check when VERIFY_TIMEOUT is not set. The
+// host can take a few minutes to cold start and load a new package
+const defaultVerifyTimeout = 5 * time.Minute
+
+// verifyPollInterval is the delay between two listings of the deployed functions
+const verifyPollInterval = 10 * time.Second
+
+// Per-function outcomes of the post-publish check. A function is unknown when the
+// functions could never be listed
+const (
+	functionLive     = "live"
+	functionMissing  = "missing"
+	functionDisabled = "disabled"
+	functionUnknown  = "unknown"
+)
+
+// deployedFunction holds the fields read from the JSON printed by
+// `az functionapp function list`
+type deployedFunction struct {
+	Name              string `json:"name"`
+	InvokeURLTemplate string `json:"invokeUrlTemplate"`
+	IsDisabled        bool   `json:"isDisabled"`
+}
+
+// verifyFunctions polls `az functionapp function list` until the host lists every
+// function of results as enabled, or VERIFY_TIMEOUT expires. The outcome of each
+// function is recorded in results and an error lists the functions that are not live
+func verifyFunctions(ctx context.Context, cfg Config, results []FunctionResult) error {
+	if cfg.DryRun {
+		planDryRun("wait up to %s for Function App %s to list %d functions (az functionapp function list)",
+			cfg.VerifyTimeout, cfg.AzureFunctionAppName, len(results))
+		return nil
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, cfg.VerifyTimeout)
+	defer cancel()
+
+	for i := range results {
+		results[i].Verification = functionUnknown
+	}
+	var listErr error
+poll:
+	for {
+		var deployed map[string]deployedFunction
+		deployed, listErr = listDeployedFunctions(verifyCtx, cfg)
+		if listErr == nil && recordFunctionStatus(results, deployed) {
+			break
+		}
+		if listErr != nil && verifyCtx.Err() == nil {
+			log.Println("Listing deployed functions failed, retrying:", listErr)
+		}
+
+		select {
+		case <-verifyCtx.Done():
+			break poll
+		case <-time.After(verifyPollInterval):
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	notLive := []string{}
+	for _, fn := range results {
+		if fn.Verification == functionLive {
+			log.Printf("Function %s is live: %s", fn.Name, fn.InvokeURL)
+			continue
+		}
+		log.Printf("Function %s is not live (%s)", fn.Name, fn.Verification)
+		notLive = append(notLive, fmt.Sprintf("%s (%s)", fn.Name, fn.Verification))
+	}
+	if len(notLive) > 0 {
+		err := fmt.Errorf("%d of %d functions are not live after %s: %s",
+			len(notLive), len(results), cfg.VerifyTimeout, strings.Join(notLive, ", "))
+		if listErr != nil {
+			err = fmt.Errorf("%w, last listing error: %v", err, listErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// listDeployedFunctions returns the functions the Function App host has loaded, keyed
+// by lowercased function name
+func listDeployedFunctions(ctx context.Context, cfg Config) (map[string]deployedFunction, error) {
+	output, err := runCommand(ctx, cfg, "", "az", "functionapp", "function", "list",
+		"--resource-group", cfg.AzureResourceGroupName,
+		"--name", cfg.AzureFunctionAppName,
+		"--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("az functionapp function list failed: %v\nOutput: %s", err, outputTail(output))
+	}
+
+	listed := []deployedFunction{}
+	if err := json.Unmarshal(output, &listed); err != nil {
+		return nil, fmt.Errorf("failed to parse az functionapp function list output: %v", err)
+	}
+	// Names are listed as <app>/<function>
+	deployed := map[string]deployedFunction{}
+	for _, fn := range listed {
+		name := fn.Name[strings.LastIndex(fn.Name, "/")+1:]
+		deployed[strings.ToLower(name)] = fn
+	}
+	return deployed, nil
+}
+
+// recordFunctionStatus sets the verification outcome of each function from the listed
+// functions and reports whether all of them are live
+func recordFunctionStatus(results []FunctionResult, deployed map[string]deployedFunction) bool {
+	allLive := true
+	for i := range results {
+		fn, ok := deployed[strings.ToLower(results[i].Name)]
+		switch {
+		case !ok:
+			results[i].Verification = functionMissing
+		case fn.IsDisabled:
+			results[i].Verification = functionDisabled
+		default:
+			results[i].Verification = functionLive
+			results[i].InvokeURL = fn.InvokeURLTemplate
+		}
+		allLive = allLive && results[i].Verification == functionLive
+	}
+	return allLive
+}
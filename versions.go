@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimum CLI versions used when MIN_AZ_VERSION or MIN_FUNC_VERSION is not set. Core
+// Tools 4.x is required for Functions runtime v4
+const (
+	defaultMinAzVersion   = "2.50.0"
+	defaultMinFuncVersion = "4.0.0"
+)
+
+// versionProbeTimeout bounds `az version` and `func --version`, which answer within
+// seconds on a working installation, so a broken one fails fast instead of after
+// CLI_TIMEOUT
+const versionProbeTimeout = 30 * time.Second
+
+// versionPattern matches the leading dotted version number of a version string
+var versionPattern = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+// cliVersions caches the probed version of each CLI so it is only run once per process.
+// The lock is held while probing so concurrent deployments probe only once as well
+var (
+	cliVersions   = map[string]string{}
+	cliVersionsMu sync.Mutex
+)
+
+// checkCLIVersions fails when the az or func CLI is older than the configured minimum.
+// The version probes are read-only, so they also run in dry-run mode
+func checkCLIVersions(ctx context.Context, cfg Config) error {
+	checks := []struct {
+		name, minimum, setting, upgrade string
+	}{
+		{"az", cfg.MinAzVersion, "MIN_AZ_VERSION", "run `az upgrade` or reinstall the Azure CLI"},
+		{"func", cfg.MinFuncVersion, "MIN_FUNC_VERSION", "install the latest Azure Functions Core Tools v4"},
+	}
+	for _, c := range checks {
+		version, err := cliVersion(ctx, cfg, c.name)
+		if err != nil {
+			return err
+		}
+		cmp, err := compareVersions(version, c.minimum)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", c.setting, err)
+		}
+		if cmp < 0 {
+			return fmt.Errorf("%s version %s is older than the required %s, please upgrade: %s (or lower %s)",
+				c.name, version, c.minimum, c.upgrade, c.setting)
+		}
+		path, _ := exec.LookPath(commandPath(cfg, c.name))
+		log.Printf("%s version %s (%s) satisfies minimum %s", c.name, version, path, c.minimum)
+	}
+	return nil
+}
+
+// cliVersion returns the version reported by `az version` or `func --version`, failing
+// when the CLI does not answer within versionProbeTimeout
+func cliVersion(ctx context.Context, cfg Config, name string) (string, error) {
+	cliVersionsMu.Lock()
+	defer cliVersionsMu.Unlock()
+
+	if version, ok := cliVersions[name]; ok {
+		return version, nil
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, versionProbeTimeout)
+	defer cancel()
+
+	var version string
+	switch name {
+	case "az":
+		output, err := runCommand(ctx, cfg, "", "az", "version", "--output", "json")
+		if err != nil {
+			return "", probeFailure(ctx, parent, "az version", err, output)
+		}
+		var versions map[string]any
+		if err := json.Unmarshal(output, &versions); err != nil {
+			return "", fmt.Errorf("failed to parse az version output: %v", err)
+		}
+		version, _ = versions["azure-cli"].(string)
+	case "func":
+		output, err := runCommand(ctx, cfg, "", "func", "--version")
+		if err != nil {
+			return "", probeFailure(ctx, parent, "func --version", err, output)
+		}
+		version = versionPattern.FindString(string(output))
+	}
+	if version == "" {
+		return "", fmt.Errorf("could not determine the %s version", name)
+	}
+
+	cliVersions[name] = version
+	return version, nil
+}
+
+// probeFailure describes a failed version probe, telling a CLI that hung apart from one
+// that exited with an error
+func probeFailure(ctx, parent context.Context, command string, err error, output []byte) error {
+	if ctx.Err() != nil && parent.Err() == nil {
+		return fmt.Errorf("%s did not finish within %s, the installation looks broken: %v", command, versionProbeTimeout, err)
+	}
+	return fmt.Errorf("%s failed: %v\nOutput: %s", command, err, outputTail(output))
+}
+
+// compareVersions compares two dotted version strings numerically, returning -1, 0 or 1.
+// Missing components count as zero and anything after the numeric part is ignored
+func compareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// parseVersion splits the leading dotted version number of s into its components
+func parseVersion(s string) ([]int, error) {
+	match := versionPattern.FindString(s)
+	if match == "" || !strings.HasPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), match) {
+		return nil, fmt.Errorf("malformed version %q", s)
+	}
+	parts := []int{}
+	for _, p := range strings.Split(match, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("malformed version %q", s)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+// Publish modes accepted by PUBLISH_MODE
+const (
+	publishModeFunc           = "func"
+	publishModeZipDeploy      = "zipdeploy"
+	publishModeRunFromPackage = "package"
+)
+
+// zipDeployPollInterval is how often the Kudu deployment status is polled
+const zipDeployPollInterval = 5 * time.Second
+
+// zipDeployExcludes lists project entries that are never included in the deployment package
+var zipDeployExcludes = map[string]bool{
+	".git":                true,
+	".vscode":             true,
+	"local.settings.json": true,
+}
+
+// kuduDeployment is the subset of the Kudu deployment status used to track a zip deploy
+type kuduDeployment struct {
+	ID         string `json:"id"`
+	Status     int    `json:"status"`
+	StatusText string `json:"status_text"`
+	Complete   bool   `json:"complete"`
+}
+
+// Kudu deployment status codes
+const (
+	kuduStatusFailed  = 3
+	kuduStatusSuccess = 4
+)
+
+// publishViaZipDeploy zips the Function App project directory and uploads it to the
+// Kudu zipdeploy endpoint, waiting for the asynchronous deployment to finish
+func publishViaZipDeploy(ctx context.Context, cfg Config, cred azcore.TokenCredential) error {
+	if cfg.DryRun {
+		planDryRun("zip %s and deploy it to Function App %s (POST %s)",
+			cfg.FunctionProjectDir, cfg.AzureFunctionAppName, zipDeployURL(cfg))
+		return nil
+	}
+
+	pkg, err := zipDirectory(cfg.FunctionProjectDir)
+	if err != nil {
+		return fmt.Errorf("failed to package project directory: %v", err)
+	}
+	log.Printf("Packaged %s (%d bytes) for zip deploy", cfg.FunctionProjectDir, len(pkg))
+
+	pl := runtime.NewPipeline("zipdeploy", "v1.0.0", runtime.PipelineOptions{
+		PerRetry: []policy.Policy{runtime.NewBearerTokenPolicy(cred, []string{resourceManagerScope(cfg)}, nil)},
+	}, nil)
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, zipDeployURL(cfg)+"?isAsync=true")
+	if err != nil {
+		return err
+	}
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(pkg)), "application/zip"); err != nil {
+		return err
+	}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		log.Println("Zip deploy completed synchronously.")
+		return nil
+	case http.StatusAccepted:
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return fmt.Errorf("zip deploy accepted without a Location header to poll")
+		}
+		return pollZipDeploy(ctx, pl, location)
+	default:
+		return runtime.NewResponseError(resp)
+	}
+}
+
+// pollZipDeploy polls the Kudu deployment status URL until the deployment completes
+func pollZipDeploy(ctx context.Context, pl runtime.Pipeline, statusURL string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(zipDeployPollInterval):
+		}
+
+		req, err := runtime.NewRequest(ctx, http.MethodGet, statusURL)
+		if err != nil {
+			return err
+		}
+		resp, err := pl.Do(req)
+		if err != nil {
+			return err
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusAccepted) {
+			return runtime.NewResponseError(resp)
+		}
+
+		var deployment kuduDeployment
+		if err := runtime.UnmarshalAsJSON(resp, &deployment); err != nil {
+			return fmt.Errorf("failed to read zip deploy status: %v", err)
+		}
+		log.Printf("Zip deploy %s status: %d %s", deployment.ID, deployment.Status, deployment.StatusText)
+
+		if deployment.Complete || deployment.Status == kuduStatusSuccess || deployment.Status == kuduStatusFailed {
+			if deployment.Status != kuduStatusSuccess {
+				return fmt.Errorf("zip deploy %s failed: %s", deployment.ID, deployment.StatusText)
+			}
+			return nil
+		}
+	}
+}
+
+// zipDeployURL returns the Kudu zipdeploy endpoint of the configured Function App. Kudu
+// accepts Resource Manager tokens of the same cloud
+func zipDeployURL(cfg Config) string {
+	c, _ := lookupAzureCloud(cfg.AzureCloud)
+	return fmt.Sprintf("https://%s.%s/api/zipdeploy", cfg.AzureFunctionAppName, c.scmSuffix)
+}
+
+// zipDirectory builds an in-memory zip archive of dir, with paths relative to dir
+func zipDirectory(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if zipDeployExcludes[d.Name()] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}